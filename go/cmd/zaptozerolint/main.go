@@ -0,0 +1,13 @@
+// Command zaptozerolint runs the zap-to-zerolog analyzer as a standalone
+// vet-style checker. Use -fix to apply the suggested rewrites.
+package main
+
+import (
+	"go-playground/pkg/ast2"
+
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(ast2.Analyzer)
+}
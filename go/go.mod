@@ -2,4 +2,11 @@ module go-playground
 
 go 1.23.0
 
-toolchain go1.24.4
\ No newline at end of file
+toolchain go1.24.4
+
+require (
+	golang.org/x/mod v0.27.0
+	golang.org/x/tools v0.36.0
+)
+
+require golang.org/x/sync v0.16.0 // indirect
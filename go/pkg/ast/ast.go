@@ -2,12 +2,15 @@ package ast
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"go/types"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -31,71 +34,168 @@ var zapToZero = map[string]string{
 }
 
 func ZapToZero() {
-	fileFlag := flag.String("file", "", "Go source file to process")
-	dirFlag := flag.String("dir", "", "Directory to process recursively")
-	inplace := flag.Bool("inplace", false, "Modify files in-place")
-	flag.Parse()
-
-	if *fileFlag == "" && *dirFlag == "" {
-		fmt.Println("Please provide -file or -dir")
-		os.Exit(1)
+	if code := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); code != 0 {
+		os.Exit(code)
 	}
+}
 
-	if *fileFlag != "" {
-		processFile(*fileFlag, *inplace)
+// run migrates the files named by the command line args and returns the
+// exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("zaptozero", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fileFlag := fs.String("file", "", "Go source file to process")
+	dirFlag := fs.String("dir", "", "Directory to process recursively")
+	inplace := fs.Bool("inplace", false, "Modify files in-place")
+	stdinFlag := fs.Bool("stdin", false, "Read one Go file from stdin and write the result to stdout")
+	filename := fs.String("filename", "", "With -stdin, path of the file being filtered; only used in positions, as nothing else is read")
+	failOnDiags := fs.Bool("fail-on-diagnostics", false, "Exit with status 1 if any call was left unchanged")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *fileFlag == "" && *dirFlag == "" && !*stdinFlag {
+		fmt.Fprintln(stdout, "Please provide -file, -dir or -stdin")
+		return 1
+	}
+
+	var diags []diagnostic
+	if *stdinFlag {
+		ok, ds := processStdin(*filename, stdin, stdout, stderr)
+		diags = ds
+		if !ok {
+			printSummary(stderr, diags)
+			return 1
+		}
+	} else if *fileFlag != "" {
+		diags = processFile(*fileFlag, *inplace, stdout)
 	} else {
 		err := filepath.Walk(*dirFlag, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
 			if !info.IsDir() && filepath.Ext(path) == ".go" {
-				processFile(path, *inplace)
+				diags = append(diags, processFile(path, *inplace, stdout)...)
 			}
 			return nil
 		})
 		if err != nil {
-			fmt.Printf("Error walking directory: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(stdout, "Error walking directory: %v\n", err)
+			return 1
 		}
 	}
+
+	printSummary(stderr, diags)
+	if *failOnDiags && len(diags) > 0 {
+		return 1
+	}
+	return 0
 }
 
-func processFile(path string, inplace bool) {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
-	if err != nil {
-		fmt.Printf("Error parsing file %s: %v\n", path, err)
+// diagnostic is a call site that was left unchanged, and why.
+type diagnostic struct {
+	pos token.Position
+	msg string
+}
+
+func printSummary(w io.Writer, diags []diagnostic) {
+	if len(diags) == 0 {
 		return
 	}
+	fmt.Fprintf(w, "Left %d call(s) unchanged:\n", len(diags))
+	for _, d := range diags {
+		fmt.Fprintf(w, "  %s: %s\n", d.pos, d.msg)
+	}
+}
 
-	modified := modifyAST(f)
+func processFile(path string, inplace bool, stdout io.Writer) []diagnostic {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stdout, "Error reading file %s: %v\n", path, err)
+		return nil
+	}
 
-	if modified {
-		var buf bytes.Buffer
-		cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 4}
-		err = cfg.Fprint(&buf, fset, f)
-		if err != nil {
-			fmt.Printf("Error printing file %s: %v\n", path, err)
-			return
-		}
+	output, modified, diags, err := rewriteSource(path, src)
+	if err != nil {
+		fmt.Fprintf(stdout, "Error processing file %s: %v\n", path, err)
+		return nil
+	}
 
-		output := buf.Bytes()
+	if modified {
 		if inplace {
 			err = ioutil.WriteFile(path, output, 0644)
 			if err != nil {
-				fmt.Printf("Error writing file %s: %v\n", path, err)
+				fmt.Fprintf(stdout, "Error writing file %s: %v\n", path, err)
 			}
 		} else {
-			fmt.Println(buf.String())
+			fmt.Fprintln(stdout, string(output))
 		}
 	}
+	return diags
 }
 
-func modifyAST(f *ast.File) bool {
+// processStdin reads one Go file from stdin and writes the rewritten
+// source, or the input unchanged, to stdout. Diagnostics go to stderr.
+// filename is only used in positions and may be empty.
+func processStdin(filename string, stdin io.Reader, stdout, stderr io.Writer) (bool, []diagnostic) {
+	if filename == "" {
+		filename = "<stdin>"
+	}
+	src, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading stdin: %v\n", err)
+		return false, nil
+	}
+
+	output, modified, diags, err := rewriteSource(filename, src)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error processing %s: %v\n", filename, err)
+		return false, diags
+	}
+	if !modified {
+		output = src
+	}
+	if _, err := stdout.Write(output); err != nil {
+		fmt.Fprintf(stderr, "Error writing stdout: %v\n", err)
+		return false, diags
+	}
+	return true, diags
+}
+
+// rewriteSource parses src as the file filename and returns the rewritten
+// source, whether anything changed and the calls left unchanged.
+func rewriteSource(filename string, src []byte) ([]byte, bool, []diagnostic, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	rw := &rewriter{fset: fset}
+	if !rw.modifyAST(f) {
+		return nil, false, rw.diags, nil
+	}
+
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 4}
+	if err := cfg.Fprint(&buf, fset, f); err != nil {
+		return nil, false, rw.diags, fmt.Errorf("printing: %w", err)
+	}
+	return buf.Bytes(), true, rw.diags, nil
+}
+
+// rewriter holds the state of rewriting one file.
+type rewriter struct {
+	fset      *token.FileSet
+	diags     []diagnostic
+	rewritten int
+}
+
+func (rw *rewriter) modifyAST(f *ast.File) bool {
 	modified := false
 	ast.Inspect(f, func(n ast.Node) bool {
 		if fd, ok := n.(*ast.FuncDecl); ok {
-			if processFunc(fd) {
+			if rw.processFunc(fd) {
 				modified = true
 			}
 			return false
@@ -116,7 +216,7 @@ func modifyAST(f *ast.File) bool {
 	return modified
 }
 
-func processFunc(fd *ast.FuncDecl) bool {
+func (rw *rewriter) processFunc(fd *ast.FuncDecl) bool {
 	if fd.Body == nil {
 		return false
 	}
@@ -129,8 +229,9 @@ func processFunc(fd *ast.FuncDecl) bool {
 		// todo
 	}
 
-	fd.Body = rewriteBlock(fd.Body)
-	return true
+	before := rw.rewritten
+	fd.Body = rw.rewriteBlock(fd.Body)
+	return rw.rewritten > before
 }
 
 func hasZapLoggerCalls(b *ast.BlockStmt) bool {
@@ -209,14 +310,14 @@ func isUtilsGetLoggerFromContext(sel *ast.SelectorExpr) bool {
 	return sel.Sel.Name == "GetLoggerFromContext"
 }
 
-func rewriteBlock(b *ast.BlockStmt) *ast.BlockStmt {
+func (rw *rewriter) rewriteBlock(b *ast.BlockStmt) *ast.BlockStmt {
 	for i := range b.List {
-		b.List[i] = rewriteStmt(b.List[i])
+		b.List[i] = rw.rewriteStmt(b.List[i])
 	}
 	return b
 }
 
-func rewriteStmt(s ast.Stmt) ast.Stmt {
+func (rw *rewriter) rewriteStmt(s ast.Stmt) ast.Stmt {
 	switch x := s.(type) {
 	case *ast.BadStmt:
 		return x
@@ -225,115 +326,115 @@ func rewriteStmt(s ast.Stmt) ast.Stmt {
 	case *ast.EmptyStmt:
 		return x
 	case *ast.LabeledStmt:
-		x.Stmt = rewriteStmt(x.Stmt)
+		x.Stmt = rw.rewriteStmt(x.Stmt)
 		return x
 	case *ast.ExprStmt:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		return x
 	case *ast.SendStmt:
-		x.Chan = rewriteExpr(x.Chan)
-		x.Value = rewriteExpr(x.Value)
+		x.Chan = rw.rewriteExpr(x.Chan)
+		x.Value = rw.rewriteExpr(x.Value)
 		return x
 	case *ast.IncDecStmt:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		return x
 	case *ast.AssignStmt:
 		for i := range x.Lhs {
-			x.Lhs[i] = rewriteExpr(x.Lhs[i])
+			x.Lhs[i] = rw.rewriteExpr(x.Lhs[i])
 		}
 		for i := range x.Rhs {
-			x.Rhs[i] = rewriteExpr(x.Rhs[i])
+			x.Rhs[i] = rw.rewriteExpr(x.Rhs[i])
 		}
 		return x
 	case *ast.GoStmt:
-		x.Call = rewriteExpr(x.Call).(*ast.CallExpr)
+		x.Call = rw.rewriteExpr(x.Call).(*ast.CallExpr)
 		return x
 	case *ast.DeferStmt:
-		x.Call = rewriteExpr(x.Call).(*ast.CallExpr)
+		x.Call = rw.rewriteExpr(x.Call).(*ast.CallExpr)
 		return x
 	case *ast.ReturnStmt:
 		for i := range x.Results {
-			x.Results[i] = rewriteExpr(x.Results[i])
+			x.Results[i] = rw.rewriteExpr(x.Results[i])
 		}
 		return x
 	case *ast.BranchStmt:
 		return x
 	case *ast.BlockStmt:
-		return rewriteBlock(x)
+		return rw.rewriteBlock(x)
 	case *ast.IfStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
-		x.Cond = rewriteExpr(x.Cond)
-		x.Body = rewriteBlock(x.Body)
+		x.Cond = rw.rewriteExpr(x.Cond)
+		x.Body = rw.rewriteBlock(x.Body)
 		if x.Else != nil {
-			x.Else = rewriteStmt(x.Else)
+			x.Else = rw.rewriteStmt(x.Else)
 		}
 		return x
 	case *ast.CaseClause:
 		for i := range x.List {
-			x.List[i] = rewriteExpr(x.List[i])
+			x.List[i] = rw.rewriteExpr(x.List[i])
 		}
 		for i := range x.Body {
-			x.Body[i] = rewriteStmt(x.Body[i])
+			x.Body[i] = rw.rewriteStmt(x.Body[i])
 		}
 		return x
 	case *ast.SwitchStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
 		if x.Tag != nil {
-			x.Tag = rewriteExpr(x.Tag)
+			x.Tag = rw.rewriteExpr(x.Tag)
 		}
-		x.Body = rewriteBlock(x.Body)
+		x.Body = rw.rewriteBlock(x.Body)
 		return x
 	case *ast.TypeSwitchStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
-		x.Assign = rewriteStmt(x.Assign)
-		x.Body = rewriteBlock(x.Body)
+		x.Assign = rw.rewriteStmt(x.Assign)
+		x.Body = rw.rewriteBlock(x.Body)
 		return x
 	case *ast.CommClause:
 		if x.Comm != nil {
-			x.Comm = rewriteStmt(x.Comm)
+			x.Comm = rw.rewriteStmt(x.Comm)
 		}
 		for i := range x.Body {
-			x.Body[i] = rewriteStmt(x.Body[i])
+			x.Body[i] = rw.rewriteStmt(x.Body[i])
 		}
 		return x
 	case *ast.SelectStmt:
-		x.Body = rewriteBlock(x.Body)
+		x.Body = rw.rewriteBlock(x.Body)
 		return x
 	case *ast.ForStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
 		if x.Cond != nil {
-			x.Cond = rewriteExpr(x.Cond)
+			x.Cond = rw.rewriteExpr(x.Cond)
 		}
 		if x.Post != nil {
-			x.Post = rewriteStmt(x.Post)
+			x.Post = rw.rewriteStmt(x.Post)
 		}
-		x.Body = rewriteBlock(x.Body)
+		x.Body = rw.rewriteBlock(x.Body)
 		return x
 	case *ast.RangeStmt:
 		if x.Key != nil {
-			x.Key = rewriteExpr(x.Key)
+			x.Key = rw.rewriteExpr(x.Key)
 		}
 		if x.Value != nil {
-			x.Value = rewriteExpr(x.Value)
+			x.Value = rw.rewriteExpr(x.Value)
 		}
-		x.X = rewriteExpr(x.X)
-		x.Body = rewriteBlock(x.Body)
+		x.X = rw.rewriteExpr(x.X)
+		x.Body = rw.rewriteBlock(x.Body)
 		return x
 	default:
-		fmt.Printf("Unhandled stmt type: %T\n", x)
+		fmt.Fprintf(os.Stderr, "Unhandled stmt type: %T\n", x)
 		return x
 	}
 }
 
-func rewriteExpr(e ast.Expr) ast.Expr {
+func (rw *rewriter) rewriteExpr(e ast.Expr) ast.Expr {
 	if e == nil {
 		return nil
 	}
@@ -345,68 +446,77 @@ func rewriteExpr(e ast.Expr) ast.Expr {
 	case *ast.BasicLit:
 		return x
 	case *ast.FuncLit:
-		x.Body = rewriteBlock(x.Body)
+		x.Body = rw.rewriteBlock(x.Body)
 		return x
 	case *ast.CompositeLit:
-		x.Type = rewriteExpr(x.Type)
+		x.Type = rw.rewriteExpr(x.Type)
 		for i := range x.Elts {
-			x.Elts[i] = rewriteExpr(x.Elts[i])
+			x.Elts[i] = rw.rewriteExpr(x.Elts[i])
 		}
 		return x
 	case *ast.ParenExpr:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		return x
 	case *ast.SelectorExpr:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		return x
 	case *ast.IndexExpr:
-		x.X = rewriteExpr(x.X)
-		x.Index = rewriteExpr(x.Index)
+		x.X = rw.rewriteExpr(x.X)
+		x.Index = rw.rewriteExpr(x.Index)
 		return x
 	case *ast.SliceExpr:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		if x.Low != nil {
-			x.Low = rewriteExpr(x.Low)
+			x.Low = rw.rewriteExpr(x.Low)
 		}
 		if x.High != nil {
-			x.High = rewriteExpr(x.High)
+			x.High = rw.rewriteExpr(x.High)
 		}
 		if x.Max != nil {
-			x.Max = rewriteExpr(x.Max)
+			x.Max = rw.rewriteExpr(x.Max)
 		}
 		return x
 	case *ast.TypeAssertExpr:
-		x.X = rewriteExpr(x.X)
-		x.Type = rewriteExpr(x.Type)
+		x.X = rw.rewriteExpr(x.X)
+		x.Type = rw.rewriteExpr(x.Type)
 		return x
 	case *ast.CallExpr:
-		x.Fun = rewriteExpr(x.Fun)
+		x.Fun = rw.rewriteExpr(x.Fun)
 		for i := range x.Args {
-			x.Args[i] = rewriteExpr(x.Args[i])
+			x.Args[i] = rw.rewriteExpr(x.Args[i])
 		}
 		if sel, ok := x.Fun.(*ast.SelectorExpr); ok {
 			if isUtilsLogger(sel.X) && logLevels[sel.Sel.Name] {
-				return createZerologCall(sel.Sel.Name, x.Args)
+				call, err := createZerologCall(sel.Sel.Name, x.Args)
+				if err != nil {
+					rw.diags = append(rw.diags, diagnostic{
+						pos: rw.fset.Position(x.Pos()),
+						msg: fmt.Sprintf("%s: %v", types.ExprString(x.Fun), err),
+					})
+					return x
+				}
+				rw.rewritten++
+				return call
 			}
 		}
 		return x
 	case *ast.StarExpr:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		return x
 	case *ast.UnaryExpr:
-		x.X = rewriteExpr(x.X)
+		x.X = rw.rewriteExpr(x.X)
 		return x
 	case *ast.BinaryExpr:
-		x.X = rewriteExpr(x.X)
-		x.Y = rewriteExpr(x.Y)
+		x.X = rw.rewriteExpr(x.X)
+		x.Y = rw.rewriteExpr(x.Y)
 		return x
 	case *ast.KeyValueExpr:
-		x.Key = rewriteExpr(x.Key)
-		x.Value = rewriteExpr(x.Value)
+		x.Key = rw.rewriteExpr(x.Key)
+		x.Value = rw.rewriteExpr(x.Value)
 		return x
 	case *ast.ArrayType:
-		x.Len = rewriteExpr(x.Len)
-		x.Elt = rewriteExpr(x.Elt)
+		x.Len = rw.rewriteExpr(x.Len)
+		x.Elt = rw.rewriteExpr(x.Elt)
 		return x
 	case *ast.StructType:
 		return x
@@ -415,21 +525,23 @@ func rewriteExpr(e ast.Expr) ast.Expr {
 	case *ast.InterfaceType:
 		return x
 	case *ast.MapType:
-		x.Key = rewriteExpr(x.Key)
-		x.Value = rewriteExpr(x.Value)
+		x.Key = rw.rewriteExpr(x.Key)
+		x.Value = rw.rewriteExpr(x.Value)
 		return x
 	case *ast.ChanType:
-		x.Value = rewriteExpr(x.Value)
+		x.Value = rw.rewriteExpr(x.Value)
 		return x
 	default:
-		fmt.Printf("Unhandled expr type: %T\n", x)
+		fmt.Fprintf(os.Stderr, "Unhandled expr type: %T\n", x)
 		return x
 	}
 }
 
-func createZerologCall(level string, args []ast.Expr) ast.Expr {
+// createZerologCall builds the zerolog chain for a zap call with args. It
+// fails on calls it cannot translate, which are then left as they are.
+func createZerologCall(level string, args []ast.Expr) (ast.Expr, error) {
 	if len(args) < 1 {
-		panic("Invalid log call: no arguments")
+		return nil, errors.New("invalid log call: no arguments")
 	}
 	msg := args[0]
 	fields := args[1:]
@@ -445,21 +557,20 @@ func createZerologCall(level string, args []ast.Expr) ast.Expr {
 	for _, field := range fields {
 		fcall, ok := field.(*ast.CallExpr)
 		if !ok {
-			panic("Field is not a call expression")
+			return nil, fmt.Errorf("field %s is not a call expression", types.ExprString(field))
 		}
 		fsel, ok := fcall.Fun.(*ast.SelectorExpr)
 		if !ok {
-			panic("Field fun is not selector")
+			return nil, fmt.Errorf("field %s is not a zap field constructor", types.ExprString(field))
 		}
 		fx, ok := fsel.X.(*ast.Ident)
 		if !ok || fx.Name != "zap" {
-			panic("Field not from zap")
+			return nil, fmt.Errorf("field %s is not from zap", types.ExprString(field))
 		}
 		zapType := fsel.Sel.Name
 		zeroType, ok := zapToZero[zapType]
 		if !ok {
-			fmt.Printf("Unknown zap field type: %s\n", zapType)
-			return base // Skip unsupported field types gracefully
+			return nil, fmt.Errorf("unsupported zap field type zap.%s", zapType)
 		}
 		newCall := &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
@@ -478,7 +589,7 @@ func createZerologCall(level string, args []ast.Expr) ast.Expr {
 		},
 		Args: []ast.Expr{msg},
 	}
-	return msgCall
+	return msgCall, nil
 }
 
 func isImportPresent(f *ast.File, path string) bool {
@@ -0,0 +1,153 @@
+package ast
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteSourceDiagnostics(t *testing.T) {
+	const header = `package p
+
+import (
+	"utils"
+
+	"go.uber.org/zap"
+)
+
+`
+	tests := []struct {
+		name  string
+		body  string
+		diags []string // messages, in order
+	}{{
+		name:  "no arguments",
+		body:  `utils.Logger.Info()`,
+		diags: []string{"utils.Logger.Info: invalid log call: no arguments"},
+	}, {
+		name:  "field is not a call",
+		body:  `utils.Logger.Info("hi", f)`,
+		diags: []string{"utils.Logger.Info: field f is not a call expression"},
+	}, {
+		name:  "field is not from zap",
+		body:  `utils.Logger.Info("hi", fields.Tenant(1))`,
+		diags: []string{"utils.Logger.Info: field fields.Tenant(1) is not from zap"},
+	}, {
+		name:  "unsupported zap field",
+		body:  `utils.Logger.Warn("hi", zap.Duration("d", 0))`,
+		diags: []string{"utils.Logger.Warn: unsupported zap field type zap.Duration"},
+	}, {
+		name: "rewritten",
+		body: `utils.Logger.Info("hi", zap.String("a", "b"))`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := header + "func f() {\n\t" + tt.body + "\n}\n"
+			out, modified, diags, err := rewriteSource("p.go", []byte(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if modified != (len(tt.diags) == 0) {
+				t.Errorf("modified = %t with diagnostics %v:\n%s", modified, diags, out)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, d.msg)
+				if d.pos.Filename != "p.go" || d.pos.Line != 10 {
+					t.Errorf("diagnostic at %s, want p.go:10", d.pos)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.diags, "\n") {
+				t.Errorf("diagnostics are %q, want %q", got, tt.diags)
+			}
+		})
+	}
+}
+
+func TestFailOnDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		args []string
+		want int
+	}{{
+		name: "diagnostics",
+		body: `utils.Logger.Info()`,
+		args: []string{"-fail-on-diagnostics"},
+		want: 1,
+	}, {
+		name: "diagnostics without the flag",
+		body: `utils.Logger.Info()`,
+		want: 0,
+	}, {
+		name: "clean",
+		body: `utils.Logger.Info("hi")`,
+		args: []string{"-fail-on-diagnostics"},
+		want: 0,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "p.go")
+			src := "package p\n\nimport \"utils\"\n\nfunc f() {\n\t" + tt.body + "\n}\n"
+			if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var stderr strings.Builder
+			args := append([]string{"-file", path}, tt.args...)
+			if got := run(args, strings.NewReader(""), io.Discard, &stderr); got != tt.want {
+				t.Errorf("exit status %d, want %d; stderr:\n%s", got, tt.want, stderr.String())
+			}
+		})
+	}
+}
+
+func TestStdin(t *testing.T) {
+	const src = `package p
+
+import (
+	"utils"
+
+	"go.uber.org/zap"
+)
+
+func f() {
+	utils.Logger.Info("hi", zap.String("a", "b"))
+	utils.Logger.Info()
+}
+`
+	tests := []struct {
+		name     string
+		args     []string
+		filename string // in the positions of the summary
+	}{{
+		name:     "filename",
+		args:     []string{"-stdin", "-filename", "pkg/filtered.go"},
+		filename: "pkg/filtered.go",
+	}, {
+		name:     "no filename",
+		args:     []string{"-stdin"},
+		filename: "<stdin>",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			if got := run(tt.args, strings.NewReader(src), &stdout, &stderr); got != 0 {
+				t.Fatalf("exit status %d; stderr:\n%s", got, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), `logger.Info().Str("a", "b").Msg("hi")`) {
+				t.Errorf("stdout is not the migrated source:\n%s", stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.filename+":11:") {
+				t.Errorf("stderr does not name %s:\n%s", tt.filename, stderr.String())
+			}
+		})
+	}
+
+	// Input that does not parse fails and leaves stdout empty.
+	var stdout strings.Builder
+	if got := run([]string{"-stdin"}, strings.NewReader("package"), &stdout, io.Discard); got != 1 || stdout.Len() != 0 {
+		t.Errorf("unparsable input: exit status %d, stdout %q", got, stdout.String())
+	}
+}
@@ -0,0 +1,160 @@
+package ast2
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// Analyzer reports zap logger calls in methods and suggests the zerolog
+// chain that ZapToZero2 would write in their place.
+var Analyzer = &analysis.Analyzer{
+	Name: "zaptozero",
+	Doc:  "report utils.Logger zap calls that can be rewritten to zerolog",
+	Run:  runAnalyzer,
+}
+
+func runAnalyzer(pass *analysis.Pass) (interface{}, error) {
+	files := make(map[string]*ast.File)
+	for _, f := range pass.Files {
+		files[pass.Fset.File(f.Pos()).Name()] = f
+	}
+	ix := indexStructs(files)
+
+	for _, f := range pass.Files {
+		if hasIgnoreFileDirective(f) {
+			continue
+		}
+		src, err := pass.ReadFile(pass.Fset.File(f.Pos()).Name())
+		if err != nil {
+			return nil, err
+		}
+		ignored := ignoredLines(pass.Fset, f, src)
+
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Body == nil || fd.Recv == nil || len(fd.Recv.List) == 0 || len(fd.Recv.List[0].Names) == 0 {
+				continue
+			}
+			if !hasZapLoggerCalls(fd.Body) {
+				continue
+			}
+			recv := fd.Recv.List[0].Names[0].Name
+			// Without a logger field there is nothing to rewrite to, so
+			// the calls are reported without a fix.
+			path, fieldErr := ix.findLogger(recvTypeName(fd))
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				if s, ok := n.(ast.Stmt); ok && ignored[pass.Fset.Position(s.Pos()).Line] {
+					return false
+				}
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || !isUtilsLogger(sel.X) || !logLevels[sel.Sel.Name] || len(call.Args) == 0 {
+					return true
+				}
+				if call.Ellipsis.IsValid() {
+					// Field slices are rewritten together with their
+					// declaration by the migrator.
+					return true
+				}
+				if fieldErr != nil {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Pos(),
+						End:     call.End(),
+						Message: fmt.Sprintf("zap call %s cannot be rewritten: %s %v", types.ExprString(call.Fun), recvTypeName(fd), fieldErr),
+					})
+					return true
+				}
+				for _, field := range call.Args[1:] {
+					if why := fieldProblem(field, nil); why != "" {
+						pass.Report(analysis.Diagnostic{
+							Pos:     call.Pos(),
+							End:     call.End(),
+							Message: fmt.Sprintf("zap call %s cannot be rewritten: %s %s", types.ExprString(call.Fun), types.ExprString(field), why),
+						})
+						return true
+					}
+				}
+				var buf bytes.Buffer
+				chain := createZerologCall(sel.Sel.Name, call.Args, loggerSelector(recv, path), nil)
+				specializeAny(chain, pass.TypesInfo.TypeOf, nil)
+				edits := importEdits(f, chain)
+				if err := format.Node(&buf, pass.Fset, chain); err != nil {
+					return true
+				}
+				pass.Report(analysis.Diagnostic{
+					Pos:     call.Pos(),
+					End:     call.End(),
+					Message: fmt.Sprintf("zap call %s can be rewritten to zerolog", types.ExprString(call.Fun)),
+					SuggestedFixes: []analysis.SuggestedFix{{
+						Message: "Rewrite to zerolog",
+						TextEdits: append([]analysis.TextEdit{{
+							Pos:     call.Pos(),
+							End:     call.End(),
+							NewText: buf.Bytes(),
+						}}, edits...),
+					}},
+				})
+				return true
+			})
+		}
+	}
+	return nil, nil
+}
+
+// importEdits returns the edits that import into f the packages the
+// generated chain refers to, and renames the references in chain to the
+// names f knows the packages by, as fixImports does for the migrator.
+func importEdits(f *ast.File, chain ast.Node) []analysis.TextEdit {
+	var edits []analysis.TextEdit
+	for _, imp := range generatedImports {
+		refs := generatedRefs(chain, imp.name)
+		if len(refs) == 0 {
+			continue
+		}
+		name, present := nameFor(f, imp)
+		switch name {
+		case imp.name:
+		case ".":
+			dequalify(chain, refs)
+		default:
+			for id := range refs {
+				id.Name = name
+			}
+		}
+		if present {
+			continue
+		}
+		spec := strconv.Quote(imp.path)
+		if name != assumedName(imp.path) {
+			spec = name + " " + spec
+		}
+		edits = append(edits, importEdit(f, spec))
+	}
+	return edits
+}
+
+// importEdit returns the edit that adds the import spec to f: to its
+// first import declaration, or after the package clause if it has none.
+func importEdit(f *ast.File, spec string) analysis.TextEdit {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		if gd.Lparen.IsValid() {
+			return analysis.TextEdit{Pos: gd.Rparen, End: gd.Rparen, NewText: []byte("\t" + spec + "\n")}
+		}
+		return analysis.TextEdit{Pos: gd.End(), End: gd.End(), NewText: []byte("\nimport " + spec)}
+	}
+	return analysis.TextEdit{Pos: f.Name.End(), End: f.Name.End(), NewText: []byte("\n\nimport " + spec)}
+}
@@ -0,0 +1,40 @@
+package ast2
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "svc")
+}
+
+// TestAnalyzerGolden checks that the fixed source type checks, imports
+// included, against the packages of testdata.
+func TestAnalyzerGolden(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
+	gopath, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev string) { build.Default.GOPATH = prev }(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filepath.Join(gopath, "src", "svc", "svc.go.golden"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("svc", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("svc.go.golden does not type check: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package ast2
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// basicMethods are the zerolog methods for values of the predeclared
+// types that zap.Any switches on. Named types are not in zap.Any's switch
+// and keep going through reflection.
+var basicMethods = map[types.BasicKind]string{
+	types.Bool:    "Bool",
+	types.String:  "Str",
+	types.Int:     "Int",
+	types.Int8:    "Int8",
+	types.Int16:   "Int16",
+	types.Int32:   "Int32",
+	types.Int64:   "Int64",
+	types.Uint:    "Uint",
+	types.Uint8:   "Uint8",
+	types.Uint16:  "Uint16",
+	types.Uint32:  "Uint32",
+	types.Uint64:  "Uint64",
+	types.Float32: "Float32",
+	types.Float64: "Float64",
+}
+
+// sliceMethods are the zerolog methods for slices of predeclared types.
+var sliceMethods = map[types.BasicKind]string{
+	types.Bool:    "Bools",
+	types.String:  "Strs",
+	types.Int:     "Ints",
+	types.Int64:   "Ints64",
+	types.Uint:    "Uints",
+	types.Uint64:  "Uints64",
+	types.Float32: "Floats32",
+	types.Float64: "Floats64",
+}
+
+// namedMethods are the zerolog methods for values of named types, by
+// package path and name.
+var namedMethods = map[string]string{
+	"time.Time":                "Time",
+	"time.Duration":            "Dur",
+	"encoding/json.RawMessage": "RawJSON",
+}
+
+// specializeAny replaces the Interface fields of a zerolog chain, which
+// zap.Any becomes, with the zerolog method for the static type of the
+// value. It follows the order of zap.Any's type switch, so that values
+// log the same, and keeps Interface for interfaces and other types zap
+// logs through reflection. typeOf returns nil for unknown types; generated
+// reports whether the run gives a type the zerolog version of its zap
+// marshaler method, and may be nil.
+func specializeAny(chain ast.Expr, typeOf func(ast.Expr) types.Type, generated func(t types.Type, zapMethod string) bool) {
+	for {
+		call, ok := chain.(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return
+		}
+		if sel.Sel.Name == "Interface" && len(call.Args) == 2 {
+			if t := typeOf(call.Args[1]); t != nil {
+				if m := methodForType(t, generated); m != "" {
+					sel.Sel = ast.NewIdent(m)
+				}
+			}
+		}
+		chain = sel.X
+	}
+}
+
+func methodForType(t types.Type, generated func(t types.Type, zapMethod string) bool) string {
+	if b, ok := t.(*types.Basic); ok && b.Info()&types.IsUntyped != 0 {
+		t = types.Default(t)
+	}
+	// Standard library types such as json.RawMessage may be aliases of
+	// types declared elsewhere.
+	if a, ok := t.(*types.Alias); ok {
+		if m := namedMethods[qualifiedName(a.Obj())]; m != "" {
+			return m
+		}
+		t = types.Unalias(t)
+	}
+	if generated == nil {
+		generated = func(types.Type, string) bool { return false }
+	}
+	switch {
+	case hasMethod(t, "MarshalZerologObject") || hasMethod(t, "MarshalLogObject") && generated(t, "MarshalLogObject"):
+		return "Object"
+	case hasMethod(t, "MarshalZerologArray") || hasMethod(t, "MarshalLogArray") && generated(t, "MarshalLogArray"):
+		return "Array"
+	}
+	if hasMethod(t, "MarshalLogObject") || hasMethod(t, "MarshalLogArray") || types.IsInterface(t) {
+		// zap.Any logs the value with its zap marshaler, which the run
+		// leaves alone, and switches on the dynamic type of an interface,
+		// which may be a marshaler even if the interface is error or
+		// fmt.Stringer.
+		return ""
+	}
+	switch t := t.(type) {
+	case *types.Pointer:
+		// zap.Any logs pointers to the types it knows by their value,
+		// before it would use a String method.
+		return ""
+	case *types.Basic:
+		return basicMethods[t.Kind()]
+	case *types.Slice:
+		if b, ok := t.Elem().(*types.Basic); ok {
+			return sliceMethods[b.Kind()]
+		}
+		if isNamed(t.Elem(), "time", "Duration") {
+			return "Durs"
+		}
+		if isNamed(t.Elem(), "time", "Time") {
+			return "Times"
+		}
+		if types.Identical(t.Elem(), errorType) {
+			return "Errs"
+		}
+		return ""
+	}
+	if n, ok := t.(*types.Named); ok {
+		if m := namedMethods[qualifiedName(n.Obj())]; m != "" {
+			return m
+		}
+	}
+	switch {
+	case types.Implements(t, errorIface):
+		return "AnErr"
+	case isStringer(t):
+		return "Stringer"
+	}
+	return ""
+}
+
+var (
+	errorType  = types.Universe.Lookup("error").Type()
+	errorIface = errorType.Underlying().(*types.Interface)
+)
+
+// hasMethod reports whether name is in the method set of t.
+func hasMethod(t types.Type, name string) bool {
+	ms := types.NewMethodSet(t)
+	for i := 0; i < ms.Len(); i++ {
+		if ms.At(i).Obj().Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isStringer reports whether t implements fmt.Stringer.
+func isStringer(t types.Type) bool {
+	sel := types.NewMethodSet(t)
+	for i := 0; i < sel.Len(); i++ {
+		fn, ok := sel.At(i).Obj().(*types.Func)
+		if !ok || fn.Name() != "String" {
+			continue
+		}
+		sig := fn.Type().(*types.Signature)
+		if sig.Params().Len() != 0 || sig.Results().Len() != 1 {
+			return false
+		}
+		b, ok := sig.Results().At(0).Type().(*types.Basic)
+		return ok && b.Kind() == types.String
+	}
+	return false
+}
+
+// localName returns the name of t, or of the type t points to, if it is
+// declared in pkg, and "" otherwise.
+func localName(t types.Type, pkg *types.Package) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	n, ok := t.(*types.Named)
+	if !ok || pkg == nil || n.Obj().Pkg() != pkg {
+		return ""
+	}
+	return n.Obj().Name()
+}
+
+// qualifiedName returns the package path and name of obj, e.g. time.Time,
+// or "" for a predeclared object.
+func qualifiedName(obj *types.TypeName) string {
+	if obj.Pkg() == nil {
+		return ""
+	}
+	return obj.Pkg().Path() + "." + obj.Name()
+}
+
+func isNamed(t types.Type, pkg, name string) bool {
+	n, ok := types.Unalias(t).(*types.Named)
+	return ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == pkg && n.Obj().Name() == name
+}
+
+// typeLoader type checks the packages of a run with go/packages, for
+// -types.
+type typeLoader struct {
+	dirs   map[string]map[string]*fileTypes // files of the package in a directory, by absolute path
+	failed map[string]bool
+}
+
+// fileTypes are the types of the expressions of one file, by source
+// span, so that they can be found from another parse of the same source.
+type fileTypes struct {
+	src   []byte
+	pkg   *types.Package
+	types map[span]types.Type
+	// marshalers are the zap marshaler methods of the package that
+	// translate to zerolog, e.g. "T.MarshalLogObject", mapped to the
+	// absolute path of their file.
+	marshalers map[string]string
+}
+
+type span struct{ start, end int }
+
+func newTypeLoader() *typeLoader {
+	return &typeLoader{dirs: make(map[string]map[string]*fileTypes), failed: make(map[string]bool)}
+}
+
+// fileTypes returns the types of the file at path with content src, or
+// nil if its package cannot be type checked, which is reported once per
+// directory. A file that differs from the one on disk is checked on its
+// own through an overlay.
+func (l *typeLoader) fileTypes(path string, src []byte, rep *report) *fileTypes {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Dir(abs)
+	files, ok := l.dirs[dir]
+	if !ok {
+		files, err = loadTypes(dir, nil)
+		if err != nil && !l.failed[dir] {
+			l.failed[dir] = true
+			rep.errors = append(rep.errors, site{pos: token.Position{Filename: dir}, call: fmt.Sprintf("type checking failed, some zap.Any fields stay Interface: %v", err)})
+		}
+		l.dirs[dir] = files
+	}
+	if ft := files[abs]; ft != nil && bytes.Equal(ft.src, src) {
+		return ft
+	}
+	files, err = loadTypes(dir, map[string][]byte{abs: src})
+	if err != nil {
+		return nil
+	}
+	return files[abs]
+}
+
+func loadTypes(dir string, overlay map[string][]byte) (map[string]*fileTypes, error) {
+	cfg := &packages.Config{
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedDeps,
+		Dir:     dir,
+		Tests:   true,
+		Overlay: overlay,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*fileTypes)
+	var firstErr error
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 && firstErr == nil {
+			firstErr = pkg.Errors[0]
+		}
+		marshalers := make(map[string]string)
+		for _, f := range pkg.Syntax {
+			tf := pkg.Fset.File(f.Pos())
+			if tf == nil || hasIgnoreFileDirective(f) {
+				continue
+			}
+			src, ok := overlay[tf.Name()]
+			if !ok {
+				if src, err = os.ReadFile(tf.Name()); err != nil {
+					continue
+				}
+			}
+			ignored := ignoredLines(pkg.Fset, f, src)
+			for _, decl := range f.Decls {
+				fd, ok := decl.(*ast.FuncDecl)
+				if !ok {
+					continue
+				}
+				k := marshalerOf(fd)
+				if k == nil || ignored[tf.Line(fd.Pos())] {
+					continue
+				}
+				if _, err := convertMarshaler(pkg.Fset, src, fd, k, "zerolog"); err == nil {
+					marshalers[recvTypeName(fd)+"."+k.zapMethod] = tf.Name()
+				}
+			}
+		}
+		for _, f := range pkg.Syntax {
+			tf := pkg.Fset.File(f.Pos())
+			if tf == nil || files[tf.Name()] != nil {
+				continue
+			}
+			src, ok := overlay[tf.Name()]
+			if !ok {
+				if src, err = os.ReadFile(tf.Name()); err != nil {
+					continue
+				}
+			}
+			ft := &fileTypes{src: src, pkg: pkg.Types, types: make(map[span]types.Type), marshalers: marshalers}
+			for e, tv := range pkg.TypesInfo.Types {
+				if e.Pos() >= f.Pos() && e.End() <= f.End() && tv.Type != nil {
+					ft.types[span{tf.Offset(e.Pos()), tf.Offset(e.End())}] = tv.Type
+				}
+			}
+			files[tf.Name()] = ft
+		}
+	}
+	return files, firstErr
+}
+
+// typeOf returns the static type of e in the file being rewritten, or nil
+// if it is not known.
+func (rw *rewriter) typeOf(e ast.Expr) types.Type {
+	return rw.exprTypes.typeOf(rw.fset, e)
+}
+
+// generatesMarshaler reports whether the run generates the zerolog
+// version of the zap marshaler method zapMethod of t: t is declared in the
+// package, the method translates, and its file is rewritten in the run.
+func (rw *rewriter) generatesMarshaler(t types.Type, zapMethod string) bool {
+	name := localName(t, rw.exprTypes.pkg)
+	if name == "" {
+		return false
+	}
+	path, ok := rw.exprTypes.marshalers[name+"."+zapMethod]
+	if !ok {
+		return false
+	}
+	abs, err := filepath.Abs(rw.path)
+	return err == nil && (path == abs || rw.cfg.rewrites(path))
+}
+
+// typeOf returns the static type of e, parsed into fset from the source
+// of ft, or nil if it is not known.
+func (ft *fileTypes) typeOf(fset *token.FileSet, e ast.Expr) types.Type {
+	if ft == nil || !e.Pos().IsValid() {
+		return nil
+	}
+	return ft.types[span{fset.Position(e.Pos()).Offset, fset.Position(e.End()).Offset}]
+}
+
+// isZapLoggerType reports whether t is a zap Logger or SugaredLogger, or
+// a pointer to one.
+func isZapLoggerType(t types.Type) bool {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	return isNamed(t, zapModule, "Logger") || isNamed(t, zapModule, "SugaredLogger")
+}
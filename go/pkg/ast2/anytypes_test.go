@@ -0,0 +1,195 @@
+package ast2
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// typedPackage writes the files of package m to a GOPATH that also holds
+// the packages of testdata, so that -types can load it offline, and
+// returns its directory.
+func typedPackage(t *testing.T, files map[string]string) string {
+	t.Helper()
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := t.TempDir()
+	t.Setenv("GO111MODULE", "off")
+	t.Setenv("GOPATH", gopath+string(os.PathListSeparator)+testdata)
+	dir := filepath.Join(gopath, "src", "m")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSpecializeMarshalers(t *testing.T) {
+	files := map[string]string{
+		"types.go": `package m
+
+import "go.uber.org/zap/zapcore"
+
+type T struct{ id string }
+
+func (t T) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", t.id)
+	return nil
+}
+
+type U struct{ id string }
+
+func (u U) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.OpenNamespace("u")
+	return nil
+}
+`,
+		"log.go": `package m
+
+import "go.uber.org/zap"
+
+func f(t T, u U) {
+	zap.L().Info("hi", zap.Any("t", t), zap.Any("u", u))
+}
+`,
+	}
+	tests := []struct {
+		name string
+		dir  bool // migrate the directory rather than log.go alone
+		want []string
+	}{{
+		// T gets a zerolog marshaler in the run, U cannot.
+		name: "directory",
+		dir:  true,
+		want: []string{`Object("t", t)`, `Interface("u", u)`},
+	}, {
+		// types.go is not rewritten, so T has no zerolog marshaler.
+		name: "single file",
+		want: []string{`Interface("t", t)`, `Interface("u", u)`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := typedPackage(t, files)
+			path := filepath.Join(dir, "log.go")
+			var out []byte
+			if tt.dir {
+				changes, _, err := MigrateDir(dir, Options{Types: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range changes {
+					if c.Path == path {
+						out = c.Content
+					}
+				}
+			} else {
+				src, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if out, _, err = Migrate(src, Options{Filename: path, Types: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("output does not contain %s:\n%s", w, out)
+				}
+			}
+		})
+	}
+}
+
+func TestMethodForType(t *testing.T) {
+	const src = `package m
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type (
+	name     string
+	myErr    struct{}
+	stringer struct{}
+	marshal  struct{}
+)
+
+func (myErr) Error() string    { return "" }
+func (stringer) String() string { return "" }
+func (marshal) Error() string   { return "" }
+
+func (marshal) MarshalLogObject(interface{}) error { return nil }
+
+var (
+	s      string
+	i64    int64
+	tm     time.Time
+	d      time.Duration
+	raw    json.RawMessage
+	e      myErr
+	str    stringer
+	m      marshal
+	strs   []string
+	durs   []time.Duration
+	errs   []error
+	ptr    *int
+	errPtr *myErr
+	n      name
+	names  []name
+	iface  interface{}
+	err    error
+	fmtStr fmt.Stringer
+)
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "m.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check("m", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		v, want string
+	}{
+		{"s", "Str"},
+		{"i64", "Int64"},
+		{"tm", "Time"},
+		{"d", "Dur"},
+		{"raw", "RawJSON"},
+		{"e", "AnErr"},
+		{"str", "Stringer"},
+		{"m", ""}, // logged by its zap marshaler
+		{"strs", "Strs"},
+		{"durs", "Durs"},
+		{"errs", "Errs"},
+		{"ptr", ""},
+		{"errPtr", ""},
+		{"n", ""},
+		{"names", ""},
+		{"iface", ""},
+		{"err", ""}, // the dynamic type may be a marshaler
+		{"fmtStr", ""},
+	}
+	for _, tt := range tests {
+		if got := methodForType(pkg.Scope().Lookup(tt.v).Type(), nil); got != tt.want {
+			t.Errorf("methodForType(%s) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package ast2
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Options configures a migration. The zero value migrates with the
+// default settings.
+type Options struct {
+	// Filename is the path of the source passed to Migrate. It names the
+	// file in positions and locates the other files of its package.
+	Filename string
+	// Config is a JSON config in the format of the -config file.
+	Config []byte
+	// Rules are rewrite rules in the format of the -rules file.
+	Rules []byte
+	// AddLoggerField adds a zerolog logger field to receiver structs
+	// that have none. Migrate only adds it to its own source.
+	AddLoggerField bool
+	// CacheDir caches results by file content, tool and settings.
+	CacheDir string
+	// Types type checks the packages being migrated, to rewrite zap.Any
+	// fields to the zerolog method for the type of their value. The
+	// packages have to build.
+	Types bool
+	// Since and Staged limit the migration to Go files changed relative
+	// to a git ref or in the index, LinesOnly further to the changed
+	// lines.
+	Since     string
+	Staged    bool
+	LinesOnly bool
+	// Verify runs each rewritten call next to the zap call it replaces,
+	// in a program built in a temporary module, and reports the calls
+	// whose level, message or fields differ. It needs the go command and
+	// the zap, zerolog and pkg/errors modules, and disables the cache.
+	Verify bool
+}
+
+// Report lists what a migration left for a person to look at.
+type Report struct {
+	Diagnostics []Diagnostic
+	Cache       *CacheStats   // nil without Options.CacheDir
+	Verify      *VerifyStats  // nil unless the Options.Verify harness ran
+	Modules     []ModuleStats // by module directory, for a go.work workspace
+}
+
+// Diagnostic is a single finding of a migration.
+type Diagnostic struct {
+	Pos      token.Position
+	Category string // one of the Category constants
+	Message  string
+	Module   string // path of the workspace module of Pos, if any
+}
+
+// Diagnostic categories, in the order Report.Print lists them.
+const (
+	CategoryError          = "error"
+	CategoryIgnored        = "ignored"
+	CategoryKey            = "key"
+	CategoryRuleConflict   = "rule-conflict"
+	CategoryMarshaler      = "marshaler"
+	CategoryDynamicFields  = "dynamic-fields"
+	CategoryField          = "field"
+	CategoryTemplate       = "template"
+	CategoryLoggerField    = "logger-field"
+	CategoryObserverHelper = "observer-helper" // also the level helper
+	CategoryTestLogger     = "test-logger"
+	CategoryGlobalInstall  = "global-install"
+	CategoryVerify         = "verify"
+	CategoryLifecycle      = "lifecycle"
+)
+
+var categoryTitles = []struct{ category, title string }{
+	{CategoryError, "Errors"},
+	{CategoryIgnored, "Ignored call site(s)"},
+	{CategoryKey, "Field key issues"},
+	{CategoryRuleConflict, "Rule conflicts left unrewritten"},
+	{CategoryMarshaler, "Marshalers left for manual conversion"},
+	{CategoryDynamicFields, "Calls with dynamic field slices left as is"},
+	{CategoryField, "Calls with fields the migrator cannot rewrite, left as is"},
+	{CategoryTemplate, "Calls with helper fields that do not fit their template, left as is"},
+	{CategoryLoggerField, "Receiver logger fields"},
+	{CategoryObserverHelper, "Packages that need a generated helper"},
+	{CategoryTestLogger, "Test logger options dropped by the rewrite"},
+	{CategoryGlobalInstall, "Global loggers left as zap or installed from several places"},
+	{CategoryVerify, "Rewritten calls whose logs differ or were not verified"},
+	{CategoryLifecycle, "Sync calls and AtomicLevels"},
+}
+
+// CacheStats counts how the files of a run were served by the cache.
+type CacheStats struct {
+	Dir                    string
+	Hits, Misses, Uncached int
+}
+
+// VerifyStats counts the rewritten calls checked with Options.Verify. Sites
+// counts those the harness ran, leaving out the ones it could not build
+// or run.
+type VerifyStats struct {
+	Sites, Matched, Differ int
+}
+
+// ModuleStats is the part of a workspace run in one module.
+type ModuleStats struct {
+	Path, Dir  string
+	Files      int            // files written
+	Categories map[string]int // diagnostics by category
+}
+
+// FileChange is a file written by MigrateDir: a migrated file or one the
+// migration created, such as the observer helper.
+type FileChange struct {
+	Path    string
+	Content []byte
+}
+
+// Migrate rewrites the zap logging in one Go source file to zerolog. It
+// returns src itself if nothing needed rewriting. Files are only read,
+// to find the receiver types of the package next to opts.Filename.
+func Migrate(src []byte, opts Options) ([]byte, Report, error) {
+	return migrate(src, opts, "config", "rules")
+}
+
+func migrate(src []byte, opts Options, configName, rulesName string) ([]byte, Report, error) {
+	filename := opts.Filename
+	if filename == "" {
+		filename = "input.go"
+	}
+	cfg, err := newConfig(opts, filepath.Dir(filename), configName, rulesName)
+	if err != nil {
+		return nil, Report{}, err
+	}
+	cfg.fields.crossFile = false
+	cfg.single = true
+	rep := &report{}
+	out, helperPkg, err := processCached(filename, src, cfg, rep)
+	if err != nil {
+		return nil, rep.export(cfg), err
+	}
+	verifyRun(cfg, rep)
+	if helperPkg != "" {
+		rep.helpers = append(rep.helpers, site{pos: token.Position{Filename: filename}, call: "package " + helperPkg + " needs the observer helper; migrate its directory to generate it"})
+	}
+	if out == nil {
+		out = src
+	}
+	return out, rep.export(cfg), nil
+}
+
+// MigrateDir rewrites the Go files under dir and returns the files that
+// changed or were created, without writing them. A file that cannot be
+// migrated is reported and skipped.
+func MigrateDir(dir string, opts Options) ([]FileChange, Report, error) {
+	cfg, err := newConfig(opts, dir, "config", "rules")
+	if err != nil {
+		return nil, Report{}, err
+	}
+	var changes []FileChange
+	index := make(map[string]int)
+	cfg.write = func(path string, data []byte) error {
+		abs, _ := filepath.Abs(path)
+		if i, ok := index[abs]; ok {
+			changes[i].Content = data
+			return nil
+		}
+		index[abs] = len(changes)
+		changes = append(changes, FileChange{Path: path, Content: data})
+		return nil
+	}
+	rep := &report{}
+	err = migrateDir(dir, cfg, rep)
+	verifyRun(cfg, rep)
+	return changes, rep.export(cfg), err
+}
+
+// newConfig builds the config of a run from opts. dir is where git is
+// asked for changed files; configName and rulesName name the config and
+// rules in errors and rule names.
+func newConfig(opts Options, dir, configName, rulesName string) (*config, error) {
+	cfg, err := parseConfig(configName, opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Rules != nil {
+		if cfg.rules, err = parseRules(rulesName, opts.Rules); err != nil {
+			return nil, err
+		}
+		if r := cfg.rules.typedRule(); r != nil && !opts.Types {
+			return nil, fmt.Errorf("%s: %s matches by type and needs -types; use strlit or intlit to match literals", r.name, r.typed)
+		}
+	}
+	cfg.fields.add = opts.AddLoggerField
+	cfg.fields.crossFile = true
+	if opts.Since != "" || opts.Staged {
+		if cfg.changed, err = changedLines(dir, opts.Since, opts.Staged); err != nil {
+			return nil, err
+		}
+		cfg.linesOnly = opts.LinesOnly
+	}
+	if opts.Types {
+		cfg.types = newTypeLoader()
+	}
+	if opts.Verify {
+		cfg.verify = newVerifier()
+	}
+	// A cache hit skips the rewrite that -verify records.
+	if opts.CacheDir != "" && !opts.Verify {
+		if cfg.cache, err = openCache(opts.CacheDir, cfg, opts.Rules); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// migrateDir rewrites the Go files under dir, module by module if dir is
+// in a go.work workspace, then adds the logger fields that are still
+// pending.
+func migrateDir(dir string, cfg *config, rep *report) error {
+	mods, err := findWorkspace(dir)
+	if err != nil {
+		return err
+	}
+	if mods != nil {
+		if err := migrateWorkspace(dir, mods, cfg, rep); err != nil {
+			return err
+		}
+		addPendingFieldsToFiles(cfg, rep)
+		return nil
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if cfg.review != nil && cfg.review.quit {
+			return filepath.SkipAll
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".go" {
+			if err := processFile(path, cfg, rep); err != nil {
+				rep.fileError(path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking directory: %w", err)
+	}
+	addPendingFieldsToFiles(cfg, rep)
+	return nil
+}
+
+// verifyRun verifies the calls rewritten in a run with -verify.
+func verifyRun(cfg *config, rep *report) {
+	if cfg.verify != nil {
+		cfg.verify.run(rep)
+	}
+}
+
+func (r *report) fileError(path string, err error) {
+	r.errors = append(r.errors, site{pos: token.Position{Filename: path}, call: err.Error()})
+}
+
+// export returns the report of a run with cfg.
+func (r *report) export(cfg *config) Report {
+	var out Report
+	add := func(category string, sites []site) {
+		for _, s := range sites {
+			out.Diagnostics = append(out.Diagnostics, Diagnostic{Pos: s.pos, Category: category, Message: s.call})
+		}
+	}
+	add(CategoryError, r.errors)
+	add(CategoryIgnored, r.ignored)
+	add(CategoryKey, r.keyIssues)
+	add(CategoryRuleConflict, r.ruleConflicts)
+	add(CategoryMarshaler, r.marshalers)
+	add(CategoryDynamicFields, r.dynamicFields)
+	add(CategoryField, r.fields)
+	add(CategoryTemplate, r.templates)
+	add(CategoryLoggerField, r.loggerFields)
+	add(CategoryObserverHelper, r.helpers)
+	add(CategoryTestLogger, r.testOptions)
+	add(CategoryVerify, r.verify)
+	add(CategoryLifecycle, r.lifecycle)
+	add(CategoryGlobalInstall, r.replaceGlobals)
+	pkgs := make([]string, 0, len(r.globalInstalls))
+	for pkg, sites := range r.globalInstalls {
+		if len(sites) > 1 {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		for _, s := range r.globalInstalls[pkg] {
+			out.Diagnostics = append(out.Diagnostics, Diagnostic{Pos: s.pos, Category: CategoryGlobalInstall, Message: fmt.Sprintf("%s: %s", pkg, s.call)})
+		}
+	}
+	if c := cfg.cache; c != nil {
+		out.Cache = &CacheStats{Dir: c.dir, Hits: c.hits, Misses: c.misses, Uncached: c.uncached}
+	}
+	if v := cfg.verify; v != nil && v.ran {
+		out.Verify = &VerifyStats{Sites: v.matched + v.differ, Matched: v.matched, Differ: v.differ}
+	}
+	if cfg.workspace != nil {
+		moduleStats(cfg.workspace, &out, cfg.written)
+	}
+	return out
+}
+
+// Print writes the report in the form the command prints it, grouped by
+// category.
+func (r Report) Print(w io.Writer) {
+	for _, ct := range categoryTitles {
+		var ds []Diagnostic
+		for _, d := range r.Diagnostics {
+			if d.Category == ct.category {
+				ds = append(ds, d)
+			}
+		}
+		if len(ds) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s (%d):\n", ct.title, len(ds))
+		for _, d := range ds {
+			fmt.Fprintf(w, "  %s: %s\n", d.Pos, d.Message)
+		}
+	}
+	if c := r.Cache; c != nil {
+		fmt.Fprintf(w, "Cache %s: %d hit(s), %d miss(es), %d file(s) not cacheable\n", c.Dir, c.Hits, c.Misses, c.Uncached)
+	}
+	if v := r.Verify; v != nil {
+		fmt.Fprintf(w, "Verified %d rewritten call(s): %d log the same, %d differ\n", v.Sites, v.Matched, v.Differ)
+	}
+	if len(r.Modules) > 0 {
+		fmt.Fprintf(w, "Modules (%d):\n", len(r.Modules))
+	}
+	for _, m := range r.Modules {
+		var counts []string
+		for _, ct := range categoryTitles {
+			if n := m.Categories[ct.category]; n > 0 {
+				counts = append(counts, fmt.Sprintf("%d %s", n, ct.category))
+			}
+		}
+		if len(counts) == 0 {
+			counts = append(counts, "nothing to report")
+		}
+		fmt.Fprintf(w, "  %s (%s): %d file(s) written; %s\n", m.Path, m.Dir, m.Files, strings.Join(counts, ", "))
+	}
+}
@@ -0,0 +1,158 @@
+package ast2
+
+import (
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+	"testing"
+)
+
+// migrateCase is an input to output case for Migrate. The output must
+// parse, contain every string of want and none of notWant, and the report
+// must have the diagnostics of the categories in diags, in order.
+type migrateCase struct {
+	name    string
+	src     string
+	opts    Options
+	want    []string
+	notWant []string
+	diags   []string
+}
+
+func runMigrateCases(t *testing.T, cases []migrateCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := c.opts
+			if opts.Filename == "" {
+				opts.Filename = "testdata/p.go"
+			}
+			out, rep, err := Migrate([]byte(c.src), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parser.ParseFile(token.NewFileSet(), opts.Filename, out, 0); err != nil {
+				t.Errorf("output does not parse: %v\n%s", err, out)
+			}
+			for _, w := range c.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("output does not contain %s:\n%s", w, out)
+				}
+			}
+			for _, w := range c.notWant {
+				if strings.Contains(string(out), w) {
+					t.Errorf("output contains %s:\n%s", w, out)
+				}
+			}
+			var got []string
+			for _, d := range rep.Diagnostics {
+				got = append(got, d.Category)
+			}
+			if strings.Join(got, " ") != strings.Join(c.diags, " ") {
+				t.Errorf("diagnostics are %v, want categories %v", rep.Diagnostics, c.diags)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src := `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	zap.L().Info("started", zap.String("id", "a"))
+}
+`
+	out, rep, err := Migrate([]byte(src), Options{Filename: "testdata/p.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `log.Info().Str("id", "a").Msg("started")`; !strings.Contains(string(out), want) {
+		t.Errorf("Migrate output does not contain %s:\n%s", want, out)
+	}
+	if strings.Contains(string(out), "go.uber.org/zap") {
+		t.Errorf("Migrate output still imports zap:\n%s", out)
+	}
+	if len(rep.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", rep.Diagnostics)
+	}
+}
+
+func TestMigrateFields(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "known fields",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f(err error) {
+	zap.L().Warn("w", zap.Int("n", 1), zap.Bool("ok", true), zap.Error(err))
+}
+`,
+		want: []string{`log.Warn().Int("n", 1).Bool("ok", true).Err(errors.Wrap(err, "from error")).Msg("w")`, `"github.com/pkg/errors"`},
+	}, {
+		// A field the migrator does not know keeps the whole call, so
+		// that nothing is dropped from the log.
+		name: "unknown field",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	zap.L().Info("started", zap.String("id", "a"), zap.Namespace("ns"))
+}
+`,
+		want:    []string{`zap.L().Info("started", zap.String("id", "a"), zap.Namespace("ns"))`},
+		notWant: []string{"zerolog"},
+		diags:   []string{CategoryField},
+	}, {
+		name: "field value",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f(field zap.Field) {
+	zap.L().Info("started", field)
+}
+`,
+		want:  []string{`zap.L().Info("started", field)`},
+		diags: []string{CategoryField},
+	}})
+}
+
+func TestMigrateStdin(t *testing.T) {
+	const src = `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	zap.L().Info("hi", zap.String("a", "b"))
+	zap.L().Info("left", fields())
+}
+
+func fields() zap.Field { return zap.String("", "") }
+`
+	var stdout, stderr strings.Builder
+	opts := Options{Filename: "testdata/filtered.go"}
+	if err := migrateStdin(opts, "config", "rules", strings.NewReader(src), &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), `log.Info().Str("a", "b").Msg("hi")`) {
+		t.Errorf("stdout is not the migrated source:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "testdata/filtered.go:7:") {
+		t.Errorf("stderr does not name the file from -filename:\n%s", stderr.String())
+	}
+
+	// A file with nothing to migrate passes through as is.
+	stdout.Reset()
+	const plain = "package p\n\nfunc f() {}\n"
+	if err := migrateStdin(opts, "config", "rules", strings.NewReader(plain), &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != plain {
+		t.Errorf("stdout is %q, want the input", stdout.String())
+	}
+}
@@ -8,8 +8,12 @@ import (
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
+
+	"golang.org/x/tools/imports"
 )
 
 var logLevels = map[string]bool{
@@ -33,51 +37,308 @@ var zapToZero = map[string]string{
 	"Time":     "Time",
 	"Any":      "Interface",
 	"Error":    "Err",
+	"Object":   "Object",
+	"Array":    "Array",
 }
 
+// ZapToZero2 is the command line front end of Migrate and MigrateDir.
 func ZapToZero2() {
 	fileFlag := flag.String("file", "", "Go source file to process")
-	dirFlag := flag.String("dir", "", "Directory to process recursively")
+	dirFlag := flag.String("dir", "", "Directory to process recursively, module by module in a go.work workspace")
 	inplace := flag.Bool("inplace", false, "Modify files in-place")
+	gomod := flag.Bool("gomod", false, "Update go.mod and go.sum after an in-place migration")
+	zerologVersion := flag.String("zerolog-version", "v1.34.0", "Version of github.com/rs/zerolog to require")
+	errorsVersion := flag.String("errors-version", "v0.9.1", "Version of github.com/pkg/errors to require")
+	configFlag := flag.String("config", "", "JSON file with key renames and other migration settings; a module's .zapmigrate.json overrides it")
+	rulesFlag := flag.String("rules", "", "File of pattern -> replacement rewrite rules")
+	addField := flag.Bool("add-logger-field", false, "Add a zerolog logger field to receiver structs that have none")
+	typesFlag := flag.Bool("types", false, "Type check the packages to log zap.Any values with the zerolog method for their type")
+	cacheDir := flag.String("cache-dir", "", "Directory caching rewrite results by file content, tool and settings")
+	interactive := flag.Bool("interactive", false, "Ask for approval of each rewritten call")
+	stateFlag := flag.String("state", ".zapmigrate-review.json", "File that records -interactive answers for resuming")
+	since := flag.String("since", "", "Only process Go files changed relative to this git ref")
+	staged := flag.Bool("staged", false, "Only process Go files touched by the staged diff")
+	linesOnly := flag.Bool("lines-only", false, "With -since or -staged, only rewrite calls on changed lines")
+	journalFlag := flag.String("journal", "", "Journal of original file contents written by -inplace (default zapmigrate-<time>.journal)")
+	undo := flag.String("undo", "", "Restore the files recorded in this journal and exit")
+	stdin := flag.Bool("stdin", false, "Read one Go file from stdin and write the result to stdout")
+	filename := flag.String("filename", "", "With -stdin, path of the file being filtered; it names the file in positions and locates its package and git changes")
+	shimDir := flag.String("shim", "", "Generate a zapshim package backed by zerolog into this directory instead of migrating")
+	shimImport := flag.String("shim-import", "", "Only point the zap imports at the zapshim package with this import path")
+	verify := flag.Bool("verify", false, "Run each rewritten call next to the zap call it replaces and report calls whose logs differ")
+	zapVersion := flag.String("zap-version", "v1.27.0", "Version of go.uber.org/zap the -verify harness runs the original calls with")
+	inventoryFlag := flag.Bool("inventory", false, "Print a histogram of the zap API used per package instead of migrating")
+	inventoryOut := flag.String("inventory-json", "zap-inventory.json", "With -inventory, file the JSON inventory is written to")
 	flag.Parse()
 
-	fmt.Printf("ZapToZero2 version")
+	fmt.Fprintln(os.Stderr, "ZapToZero2 version")
+
+	if *undo != "" {
+		n, err := undoJournal(*undo)
+		if err != nil {
+			fmt.Printf("Error undoing %s: %v\n", *undo, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Restored %d file write(s) from %s\n", n, *undo)
+		return
+	}
 
-	if *fileFlag == "" && *dirFlag == "" {
-		fmt.Println("Please provide -file or -dir")
+	if *fileFlag == "" && *dirFlag == "" && !*stdin {
+		fmt.Println("Please provide -file, -dir or -stdin")
+		os.Exit(1)
+	}
+	if *gomod && !*inplace {
+		fmt.Println("-gomod updates go.mod after an in-place migration and needs -inplace")
 		os.Exit(1)
 	}
+	if *inventoryFlag {
+		var data []byte
+		if *configFlag != "" {
+			var err error
+			if data, err = os.ReadFile(*configFlag); err != nil {
+				fmt.Printf("reading config: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		cfg, err := parseConfig(*configFlag, data)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := runInventory(*fileFlag, *dirFlag, *inventoryOut, *typesFlag, cfg); err != nil {
+			fmt.Printf("Error building inventory: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	if *fileFlag != "" {
-		if err := processFile(*fileFlag, *inplace); err != nil {
-			fmt.Printf("Error processing file %s: %v\n", *fileFlag, err)
+	opts := Options{
+		AddLoggerField: *addField,
+		Types:          *typesFlag,
+		CacheDir:       *cacheDir,
+		Since:          *since,
+		Staged:         *staged,
+		LinesOnly:      *linesOnly,
+		Verify:         *verify,
+	}
+	var err error
+	if *configFlag != "" {
+		if opts.Config, err = os.ReadFile(*configFlag); err != nil {
+			fmt.Printf("reading config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *rulesFlag != "" {
+		if opts.Rules, err = os.ReadFile(*rulesFlag); err != nil {
+			fmt.Printf("reading rules: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *stdin {
+		// Stdout carries the source, so nothing is written in place.
+		opts.Filename = *filename
+		if opts.Filename == "" {
+			opts.Filename = "<stdin>"
+		}
+		if err := migrateStdin(opts, *configFlag, *rulesFlag, os.Stdin, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", opts.Filename, err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	err := filepath.Walk(*dirFlag, func(path string, info os.FileInfo, err error) error {
+	dir := *dirFlag
+	if dir == "" {
+		dir = filepath.Dir(*fileFlag)
+	}
+	if *interactive {
+		// Answers given in review are not part of the cache key.
+		opts.CacheDir = ""
+	}
+	cfg, err := newConfig(opts, dir, *configFlag, *rulesFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.verify != nil {
+		cfg.verify.versions[zapModule] = *zapVersion
+		cfg.verify.versions[zerologImport.path] = *zerologVersion
+		cfg.verify.versions["github.com/pkg/errors"] = *errorsVersion
+	}
+	var jr *journal
+	if *inplace {
+		path := *journalFlag
+		if path == "" {
+			path = fmt.Sprintf("zapmigrate-%d.journal", time.Now().Unix())
+		}
+		jr, err = openJournal(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer jr.Close()
+		defer fmt.Fprintf(os.Stderr, "Undo with -undo %s\n", path)
+		cfg.write = jr.write
+	} else {
+		cfg.write = printFile
+	}
+	if *interactive {
+		cfg.review, err = newReviewer(os.Stdin, os.Stderr, *stateFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer cfg.review.finish()
+	}
+
+	rep := &report{}
+	defer func() { rep.export(cfg).Print(os.Stderr) }()
+
+	if *shimDir != "" || *shimImport != "" {
+		u, err := scanShimUsage(*fileFlag, *dirFlag)
 		if err != nil {
-			return err
+			fmt.Printf("Error scanning zap usage: %v\n", err)
+			os.Exit(1)
 		}
-		if !info.IsDir() && filepath.Ext(path) == ".go" {
-			if err := processFile(path, *inplace); err != nil {
-				fmt.Printf("Error processing file %s: %v\n", path, err)
+		u.reportZapcore(rep)
+		if *shimDir != "" {
+			if err := writeShim(u, *shimDir, cfg, rep); err != nil {
+				fmt.Printf("Error generating shim: %v\n", err)
+				os.Exit(1)
 			}
 		}
-		return nil
-	})
-	if err != nil {
-		fmt.Printf("Error walking directory: %v\n", err)
+		if *shimImport != "" {
+			if err := shimImports(*fileFlag, *dirFlag, *shimImport, cfg, rep); err != nil {
+				fmt.Printf("Error walking directory: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	if *gomod {
+		pins := map[string]string{
+			"github.com/rs/zerolog": *zerologVersion,
+			"github.com/pkg/errors": *errorsVersion,
+		}
+		defer func() {
+			// In a workspace, each module the run wrote to gets its own
+			// requirements.
+			roots := cfg.writtenModules()
+			if cfg.workspace == nil {
+				// -file is processed rather than -dir when both are set.
+				path := *fileFlag
+				if path == "" {
+					path = *dirFlag
+				}
+				root, err := findModuleRoot(path)
+				if err != nil {
+					fmt.Printf("Error updating go.mod: %v\n", err)
+					return
+				}
+				roots = []string{root}
+			}
+			for _, root := range roots {
+				if err := updateGoMod(root, pins, jr); err != nil {
+					fmt.Printf("Error updating go.mod in %s: %v\n", root, err)
+				}
+			}
+		}()
+	}
+
+	if *fileFlag != "" {
+		cfg.single = true
+		fcfg, err := cfg.forFile(*fileFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := processFile(*fileFlag, fcfg, rep); err != nil {
+			fmt.Printf("Error processing file %s: %v\n", *fileFlag, err)
+			rep.export(cfg).Print(os.Stderr)
+			os.Exit(1)
+		}
+		addPendingFieldsToFiles(cfg, rep)
+		verifyRun(cfg, rep)
+		return
+	}
+
+	if err := migrateDir(*dirFlag, cfg, rep); err != nil {
+		fmt.Printf("Error %v\n", err)
+		rep.export(cfg).Print(os.Stderr)
 		os.Exit(1)
 	}
+	verifyRun(cfg, rep)
+}
+
+// printFile is the output of a run without -inplace. Files that do not
+// exist yet are headed by their path.
+func printFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err != nil {
+		fmt.Printf("// %s\n", path)
+	}
+	fmt.Println(string(data))
+	return nil
 }
 
-func processFile(path string, inplace bool) error {
+func processFile(path string, cfg *config, rep *report) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	out, helperPkg, err := processCached(path, src, cfg, rep)
+	if err != nil || out == nil {
+		return err
+	}
+
+	if err := cfg.output(path, out); err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+	if helperPkg != "" {
+		return writeObserverHelper(path, helperPkg, cfg)
+	}
+	return nil
+}
+
+// migrateStdin is the filter mode: it reads one Go file from stdin and
+// writes the result of Migrate to stdout. opts.Filename stands in for the
+// file's path in positions and path-based rules.
+func migrateStdin(opts Options, configName, rulesName string, stdin io.Reader, stdout, stderr io.Writer) error {
+	src, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("reading stdin: %w", err)
+	}
+	out, rep, err := migrate(src, opts, configName, rulesName)
+	rep.Print(stderr)
+	if err != nil {
+		return err
+	}
+	if _, err := stdout.Write(out); err != nil {
+		return fmt.Errorf("writing stdout: %w", err)
+	}
+	return nil
+}
+
+// processSource rewrites the source of the file at path. It returns nil
+// if nothing changed, and the package name if the observer helper is
+// needed next to the file.
+func processSource(path string, src []byte, cfg *config, rep *report) ([]byte, string, error) {
+	var lines map[int]bool
+	if cfg.changed != nil {
+		changed, ok := cfg.changedLines(path)
+		if !ok {
+			return nil, "", nil
+		}
+		if cfg.linesOnly {
+			lines = changed
+		}
+	}
+
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
 	if err != nil {
-		return fmt.Errorf("parsing file: %w", err)
+		return nil, "", fmt.Errorf("parsing file: %w", err)
 	}
 
 	// Find receiver names for methods
@@ -89,33 +350,100 @@ func processFile(path string, inplace bool) error {
 		return true
 	})
 
-	modified := modifyAST(f, receivers)
+	rw := &rewriter{
+		fset:      fset,
+		path:      path,
+		file:      f,
+		receivers: receivers,
+		ignored:   ignoredLines(fset, f, src),
+		lines:     lines,
+		cfg:       cfg,
+		report:    rep,
+	}
+
+	if cfg.types != nil {
+		rw.exprTypes = cfg.types.fileTypes(path, src, rep)
+	}
+	if cfg.review != nil {
+		cfg.review.begin(path, src)
+	}
+
+	if hasIgnoreFileDirective(f) {
+		rw.ignoreNode(f)
+		return nil, "", nil
+	}
+
+	rw.recordGlobalInstalls(f, path)
+	rw.reportSharedAtomicLevels(f)
+
+	modified := rw.modifyAST(f)
+	if rw.addPendingFields() {
+		rw.fixImports(f)
+		modified = true
+	}
+	if rw.rewriteTestLoggers(f) {
+		modified = true
+	}
+	generated := rw.convertMarshalers(f, src)
+	if len(generated) > 0 {
+		modified = true
+	}
 
 	if !modified {
-		return nil
+		return nil, "", nil
 	}
 
 	var buf bytes.Buffer
-	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 4}
-	if err := cfg.Fprint(&buf, fset, f); err != nil {
-		return fmt.Errorf("printing file: %w", err)
+	pcfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 4}
+	if err := pcfg.Fprint(&buf, fset, f); err != nil {
+		return nil, "", fmt.Errorf("printing file: %w", err)
 	}
-
-	if inplace {
-		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
-			return fmt.Errorf("writing file: %w", err)
+	for _, text := range generated {
+		buf.WriteString("\n" + text + "\n")
+	}
+	// Sort and group the imports the way goimports does.
+	out, err := imports.Process(path, buf.Bytes(), &imports.Options{FormatOnly: true, Comments: true, TabIndent: true, TabWidth: 8})
+	if err != nil {
+		return nil, "", fmt.Errorf("formatting file: %w", err)
+	}
+	if rw.levelHelper {
+		cfg.levelHelpers++
+		if !cfg.fields.crossFile {
+			rep.helpers = append(rep.helpers, site{pos: token.Position{Filename: path}, call: "package " + f.Name.Name + " needs the level helper; migrate its directory to generate it"})
+		} else if err := writeLevelHelper(path, f.Name.Name, cfg); err != nil {
+			rep.fileError(path, err)
 		}
-	} else {
-		fmt.Println(buf.String())
 	}
-	return nil
+	return out, rw.observerPkg, nil
+}
+
+// rewriter holds the per-file state shared by the rewrite functions.
+type rewriter struct {
+	fset      *token.FileSet
+	path      string
+	file      *ast.File
+	receivers map[*ast.BlockStmt]string
+	ignored   map[int]bool // lines covered by a //zapmigrate:ignore directive
+	lines     map[int]bool // with -lines-only, the lines that may be rewritten
+	cfg       *config
+	report    *report
+
+	types       typeIndex                   // struct types of the package, built on first use
+	recv        string                      // receiver name of the method being rewritten
+	loggerPath  []string                    // selector path from recv to its zerolog logger, nil if it has none
+	rewritten   int                         // number of calls rewritten so far
+	observerPkg string                      // package that needs the generated observer helper
+	slices      map[*ast.Object]*fieldSlice // field slices of the function being rewritten
+	exprTypes   *fileTypes                  // with -types, the types of the file's expressions
+	levelHelper bool                        // an AtomicLevel was served over HTTP
 }
 
-func modifyAST(f *ast.File, receivers map[*ast.BlockStmt]string) bool {
+func (rw *rewriter) modifyAST(f *ast.File) bool {
+	used := usedImports(f)
 	modified := false
 	ast.Inspect(f, func(n ast.Node) bool {
 		if fd, ok := n.(*ast.FuncDecl); ok && fd.Body != nil {
-			if processFunc(fd, receivers) {
+			if rw.processFunc(fd) {
 				modified = true
 			}
 			return false
@@ -124,32 +452,30 @@ func modifyAST(f *ast.File, receivers map[*ast.BlockStmt]string) bool {
 	})
 
 	if modified {
-		if !isImportPresent(f, "github.com/pkg/errors") {
-			addImport(f, "github.com/pkg/errors", "")
-		}
-		if !isImportPresent(f, "github.com/rs/zerolog") {
-			addImport(f, "github.com/rs/zerolog", "")
-		}
-		if !usesZap(f) {
-			removeImport(f, "go.uber.org/zap")
-		}
+		rw.fixImports(f)
+		removeUnusedImports(rw.fset, f, used)
 	}
 	return modified
 }
 
-func processFunc(fd *ast.FuncDecl, receivers map[*ast.BlockStmt]string) bool {
+func (rw *rewriter) processFunc(fd *ast.FuncDecl) bool {
 	if fd.Body == nil {
 		return false
 	}
-	if !hasZapLoggerCalls(fd.Body) {
+	if rw.cfg.rules == nil && !hasZapLoggerCalls(fd.Body) {
 		return false
 	}
-	recv, ok := receivers[fd.Body]
-	if !ok {
-		return false // Skip if no receiver
+	// Without a receiver only the global logger calls are rewritten.
+	rw.recv = rw.receivers[fd.Body]
+	rw.loggerPath = nil
+	if rw.recv != "" && usesUtilsLogger(fd.Body) {
+		rw.loggerPath = rw.receiverLogger(fd)
 	}
-	fd.Body = rewriteBlock(fd.Body, recv)
-	return true
+	before := rw.rewritten
+	rw.rewriteAtomicLevels(fd.Body)
+	rw.slices = rw.convertFieldSlices(fd.Body)
+	fd.Body = rw.rewriteBlock(fd.Body)
+	return rw.rewritten > before
 }
 
 func hasZapLoggerCalls(b *ast.BlockStmt) bool {
@@ -164,6 +490,19 @@ func hasZapLoggerCalls(b *ast.BlockStmt) bool {
 					found = true
 					return false
 				}
+				if level, _ := globalLevel(sel); level != "" {
+					found = true
+					return false
+				}
+			}
+			if isReplaceGlobals(call) || isPkgCall(call, "zap", "NewAtomicLevel") || isPkgCall(call, "zap", "NewAtomicLevelAt") {
+				found = true
+				return false
+			}
+			// Sync calls are told apart from others while rewriting.
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Sync" && len(call.Args) == 0 {
+				found = true
+				return false
 			}
 		}
 		return true
@@ -180,170 +519,267 @@ func isUtilsLogger(e ast.Expr) bool {
 	return ok && x.Name == "utils" && sel.Sel.Name == "Logger"
 }
 
-func rewriteBlock(b *ast.BlockStmt, recv string) *ast.BlockStmt {
-	for i := range b.List {
-		b.List[i] = rewriteStmt(b.List[i], recv)
-	}
+func (rw *rewriter) rewriteBlock(b *ast.BlockStmt) *ast.BlockStmt {
+	b.List = rw.rewriteStmts(b.List)
 	return b
 }
 
-func rewriteStmt(s ast.Stmt, recv string) ast.Stmt {
+// rewriteStmts rewrites a statement list, dropping the statements that
+// rewriteStmt removed.
+func (rw *rewriter) rewriteStmts(list []ast.Stmt) []ast.Stmt {
+	out := list[:0]
+	for _, s := range list {
+		if s = rw.rewriteStmt(s); s != nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (rw *rewriter) rewriteStmt(s ast.Stmt) ast.Stmt {
+	if rw.isIgnored(s) {
+		rw.ignoreNode(s)
+		return s
+	}
 	switch x := s.(type) {
 	case *ast.ExprStmt:
-		x.X = rewriteExpr(x.X, recv)
+		if isReplaceGlobals(x.X) {
+			return rw.rewriteReplaceGlobals(x)
+		}
+		if rw.zapSync(x.X) != nil {
+			return rw.rewriteSyncStmt(x, func(c *ast.CallExpr) ast.Stmt { return &ast.ExprStmt{X: c} })
+		}
+		x.X = rw.rewriteExpr(x.X)
 	case *ast.AssignStmt:
+		if len(x.Rhs) == 1 && rw.zapSync(x.Rhs[0]) != nil && allBlank(x.Lhs) {
+			return rw.rewriteSyncStmt(x, func(c *ast.CallExpr) ast.Stmt {
+				return &ast.AssignStmt{Lhs: x.Lhs, Tok: x.Tok, Rhs: []ast.Expr{c}}
+			})
+		}
 		for i := range x.Lhs {
-			x.Lhs[i] = rewriteExpr(x.Lhs[i], recv)
+			x.Lhs[i] = rw.rewriteExpr(x.Lhs[i])
 		}
 		for i := range x.Rhs {
-			x.Rhs[i] = rewriteExpr(x.Rhs[i], recv)
+			x.Rhs[i] = rw.rewriteExpr(x.Rhs[i])
 		}
 	case *ast.IfStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init, recv)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
-		x.Cond = rewriteExpr(x.Cond, recv)
-		x.Body = rewriteBlock(x.Body, recv)
+		x.Cond = rw.rewriteExpr(x.Cond)
+		x.Body = rw.rewriteBlock(x.Body)
 		if x.Else != nil {
-			x.Else = rewriteStmt(x.Else, recv)
+			x.Else = rw.rewriteStmt(x.Else)
 		}
 	case *ast.BlockStmt:
-		rewriteBlock(x, recv)
+		rw.rewriteBlock(x)
 	case *ast.ForStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init, recv)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
 		if x.Cond != nil {
-			x.Cond = rewriteExpr(x.Cond, recv)
+			x.Cond = rw.rewriteExpr(x.Cond)
 		}
 		if x.Post != nil {
-			x.Post = rewriteStmt(x.Post, recv)
+			x.Post = rw.rewriteStmt(x.Post)
 		}
-		x.Body = rewriteBlock(x.Body, recv)
+		x.Body = rw.rewriteBlock(x.Body)
 	case *ast.RangeStmt:
 		if x.Key != nil {
-			x.Key = rewriteExpr(x.Key, recv)
+			x.Key = rw.rewriteExpr(x.Key)
 		}
 		if x.Value != nil {
-			x.Value = rewriteExpr(x.Value, recv)
+			x.Value = rw.rewriteExpr(x.Value)
 		}
-		x.X = rewriteExpr(x.X, recv)
-		x.Body = rewriteBlock(x.Body, recv)
+		x.X = rw.rewriteExpr(x.X)
+		x.Body = rw.rewriteBlock(x.Body)
 	case *ast.SwitchStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init, recv)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
 		if x.Tag != nil {
-			x.Tag = rewriteExpr(x.Tag, recv)
+			x.Tag = rw.rewriteExpr(x.Tag)
 		}
-		x.Body = rewriteBlock(x.Body, recv)
+		x.Body = rw.rewriteBlock(x.Body)
 	case *ast.TypeSwitchStmt:
 		if x.Init != nil {
-			x.Init = rewriteStmt(x.Init, recv)
+			x.Init = rw.rewriteStmt(x.Init)
 		}
-		x.Assign = rewriteStmt(x.Assign, recv)
-		x.Body = rewriteBlock(x.Body, recv)
+		x.Assign = rw.rewriteStmt(x.Assign)
+		x.Body = rw.rewriteBlock(x.Body)
 	case *ast.DeferStmt:
-		x.Call = rewriteExpr(x.Call, recv).(*ast.CallExpr)
+		if rw.zapSync(x.Call) != nil {
+			return rw.rewriteSyncStmt(x, func(c *ast.CallExpr) ast.Stmt { return &ast.DeferStmt{Call: c} })
+		}
+		empty := isEmptyFuncCall(x.Call)
+		if call, ok := rw.rewriteExpr(x.Call).(*ast.CallExpr); ok {
+			x.Call = call
+		}
+		if !empty && isEmptyFuncCall(x.Call) {
+			// Its Sync calls were removed.
+			rw.lifecycleSite(x.Pos(), "removed the emptied defer func() {}()")
+			return nil
+		}
 	case *ast.GoStmt:
-		x.Call = rewriteExpr(x.Call, recv).(*ast.CallExpr)
+		if call, ok := rw.rewriteExpr(x.Call).(*ast.CallExpr); ok {
+			x.Call = call
+		}
 	case *ast.ReturnStmt:
 		for i := range x.Results {
-			x.Results[i] = rewriteExpr(x.Results[i], recv)
+			x.Results[i] = rw.rewriteExpr(x.Results[i])
 		}
 	case *ast.LabeledStmt:
-		x.Stmt = rewriteStmt(x.Stmt, recv)
+		if x.Stmt = rw.rewriteStmt(x.Stmt); x.Stmt == nil {
+			x.Stmt = &ast.EmptyStmt{Semicolon: x.Colon, Implicit: true}
+		}
 	case *ast.SendStmt:
-		x.Chan = rewriteExpr(x.Chan, recv)
-		x.Value = rewriteExpr(x.Value, recv)
+		x.Chan = rw.rewriteExpr(x.Chan)
+		x.Value = rw.rewriteExpr(x.Value)
 	case *ast.IncDecStmt:
-		x.X = rewriteExpr(x.X, recv)
+		x.X = rw.rewriteExpr(x.X)
 	case *ast.CommClause:
 		if x.Comm != nil {
-			x.Comm = rewriteStmt(x.Comm, recv)
-		}
-		for i := range x.Body {
-			x.Body[i] = rewriteStmt(x.Body[i], recv)
+			x.Comm = rw.rewriteStmt(x.Comm)
 		}
+		x.Body = rw.rewriteStmts(x.Body)
 	case *ast.SelectStmt:
-		x.Body = rewriteBlock(x.Body, recv)
+		x.Body = rw.rewriteBlock(x.Body)
 	case *ast.CaseClause:
 		for i := range x.List {
-			x.List[i] = rewriteExpr(x.List[i], recv)
-		}
-		for i := range x.Body {
-			x.Body[i] = rewriteStmt(x.Body[i], recv)
+			x.List[i] = rw.rewriteExpr(x.List[i])
 		}
+		x.Body = rw.rewriteStmts(x.Body)
 	}
 	return s
 }
 
-func rewriteExpr(e ast.Expr, recv string) ast.Expr {
+func (rw *rewriter) rewriteExpr(e ast.Expr) ast.Expr {
 	if e == nil {
 		return nil
 	}
 	switch x := e.(type) {
 	case *ast.CallExpr:
-		x.Fun = rewriteExpr(x.Fun, recv)
+		x.Fun = rw.rewriteExpr(x.Fun)
 		for i := range x.Args {
-			x.Args[i] = rewriteExpr(x.Args[i], recv)
-		}
-		if sel, ok := x.Fun.(*ast.SelectorExpr); ok {
-			if isUtilsLogger(sel.X) && logLevels[sel.Sel.Name] {
-				return createZerologCall(sel.Sel.Name, x.Args, recv)
-			}
+			x.Args[i] = rw.rewriteExpr(x.Args[i])
 		}
 	case *ast.ParenExpr:
-		x.X = rewriteExpr(x.X, recv)
+		x.X = rw.rewriteExpr(x.X)
 	case *ast.SelectorExpr:
-		x.X = rewriteExpr(x.X, recv)
+		x.X = rw.rewriteExpr(x.X)
 	case *ast.IndexExpr:
-		x.X = rewriteExpr(x.X, recv)
-		x.Index = rewriteExpr(x.Index, recv)
+		x.X = rw.rewriteExpr(x.X)
+		x.Index = rw.rewriteExpr(x.Index)
 	case *ast.SliceExpr:
-		x.X = rewriteExpr(x.X, recv)
+		x.X = rw.rewriteExpr(x.X)
 		if x.Low != nil {
-			x.Low = rewriteExpr(x.Low, recv)
+			x.Low = rw.rewriteExpr(x.Low)
 		}
 		if x.High != nil {
-			x.High = rewriteExpr(x.High, recv)
+			x.High = rw.rewriteExpr(x.High)
 		}
 		if x.Max != nil {
-			x.Max = rewriteExpr(x.Max, recv)
+			x.Max = rw.rewriteExpr(x.Max)
 		}
 	case *ast.TypeAssertExpr:
-		x.X = rewriteExpr(x.X, recv)
-		x.Type = rewriteExpr(x.Type, recv)
+		x.X = rw.rewriteExpr(x.X)
+		x.Type = rw.rewriteExpr(x.Type)
 	case *ast.FuncLit:
-		x.Body = rewriteBlock(x.Body, recv)
+		x.Body = rw.rewriteBlock(x.Body)
 	case *ast.CompositeLit:
-		x.Type = rewriteExpr(x.Type, recv)
+		x.Type = rw.rewriteExpr(x.Type)
 		for i := range x.Elts {
-			x.Elts[i] = rewriteExpr(x.Elts[i], recv)
+			x.Elts[i] = rw.rewriteExpr(x.Elts[i])
 		}
 	case *ast.StarExpr:
-		x.X = rewriteExpr(x.X, recv)
+		x.X = rw.rewriteExpr(x.X)
 	case *ast.UnaryExpr:
-		x.X = rewriteExpr(x.X, recv)
+		x.X = rw.rewriteExpr(x.X)
 	case *ast.BinaryExpr:
-		x.X = rewriteExpr(x.X, recv)
-		x.Y = rewriteExpr(x.Y, recv)
+		x.X = rw.rewriteExpr(x.X)
+		x.Y = rw.rewriteExpr(x.Y)
 	case *ast.KeyValueExpr:
-		x.Key = rewriteExpr(x.Key, recv)
-		x.Value = rewriteExpr(x.Value, recv)
+		x.Key = rw.rewriteExpr(x.Key)
+		x.Value = rw.rewriteExpr(x.Value)
 	case *ast.ArrayType:
-		x.Len = rewriteExpr(x.Len, recv)
-		x.Elt = rewriteExpr(x.Elt, recv)
+		x.Len = rw.rewriteExpr(x.Len)
+		x.Elt = rw.rewriteExpr(x.Elt)
 	case *ast.MapType:
-		x.Key = rewriteExpr(x.Key, recv)
-		x.Value = rewriteExpr(x.Value, recv)
+		x.Key = rw.rewriteExpr(x.Key)
+		x.Value = rw.rewriteExpr(x.Value)
 	case *ast.ChanType:
-		x.Value = rewriteExpr(x.Value, recv)
+		x.Value = rw.rewriteExpr(x.Value)
+	}
+	// A rule may produce a zap call that the built-in rewrites handle.
+	orig := e
+	if out, ok := rw.applyRules(e); ok {
+		e = out
+	}
+	if call, ok := e.(*ast.CallExpr); ok {
+		return rw.rewriteCall(call, orig)
 	}
 	return e
 }
 
-func createZerologCall(level string, args []ast.Expr, recv string) ast.Expr {
+// rewriteCall rewrites a zap logging call to zerolog. orig is the node the
+// call replaces in the source, for review.
+func (rw *rewriter) rewriteCall(x *ast.CallExpr, orig ast.Expr) ast.Expr {
+	sel, ok := x.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return x
+	}
+	if x.Ellipsis.IsValid() && isLogCall(x) {
+		// The call was accepted with the slice it spreads by
+		// convertFieldSlices.
+		chain := rw.spreadFields(x)
+		if chain == nil {
+			return x
+		}
+		rw.rewritten++
+		return chain
+	}
+	if isUtilsLogger(sel.X) && logLevels[sel.Sel.Name] && rw.loggerPath != nil {
+		if !rw.checkFields(x, orig, logFields(x.Args)) {
+			return x
+		}
+		chain := createZerologCall(sel.Sel.Name, x.Args, loggerSelector(rw.recv, rw.loggerPath), rw.cfg.FieldTemplates)
+		rw.normalizeChain(chain)
+		if rw.accept(orig, chain) {
+			rw.rewritten++
+			if rw.cfg.verify != nil {
+				rw.recordVerify(x, chain)
+			}
+			return chain
+		}
+	}
+	if rw.zapSync(x) != nil {
+		return rw.rewriteSyncExpr(x, orig)
+	}
+	if level, _ := globalLevel(sel); level != "" {
+		if isPkgCall(sel.X.(*ast.CallExpr), "zap", "L") && !rw.checkFields(x, orig, logFields(x.Args)) {
+			return x
+		}
+		chain := createGlobalCall(x, rw.cfg.FieldTemplates)
+		rw.normalizeChain(chain)
+		if rw.accept(orig, chain) {
+			rw.rewritten++
+			if rw.cfg.verify != nil {
+				rw.recordVerify(x, chain)
+			}
+			return chain
+		}
+	}
+	return x
+}
+
+func createZerologCall(level string, args []ast.Expr, logger ast.Expr, templates []*fieldTemplate) ast.Expr {
+	return zerologChain(logger, level, args, templates)
+}
+
+// zerologChain builds logger.Level().Field(...)...Msg(msg) from the
+// arguments of a zap logging call. Fields built by helpers are expanded
+// with the matching template.
+func zerologChain(logger ast.Expr, level string, args []ast.Expr, templates []*fieldTemplate) ast.Expr {
 	if len(args) < 1 {
 		return args[0] // Skip invalid calls
 	}
@@ -364,20 +800,55 @@ func createZerologCall(level string, args []ast.Expr, recv string) ast.Expr {
 	}
 
 	// Start chain: r.logger.Level()
-	base := &ast.CallExpr{
-		Fun: &ast.SelectorExpr{
-			X:   &ast.SelectorExpr{X: ast.NewIdent(recv), Sel: ast.NewIdent("logger")},
-			Sel: ast.NewIdent(level),
-		},
+	var curr ast.Expr = &ast.CallExpr{
+		Fun: &ast.SelectorExpr{X: logger, Sel: ast.NewIdent(level)},
+	}
+	curr = addFields(curr, fields, templates)
+
+	// If msg was err.Error(), add .Err() and set msg to ""
+	if isErrMsg {
+		newCall := &ast.CallExpr{
+			Fun: &ast.SelectorExpr{X: curr, Sel: ast.NewIdent("Err")},
+			Args: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("errors"),
+						Sel: ast.NewIdent("Wrap"),
+					},
+					Args: []ast.Expr{
+						errExpr,
+						&ast.BasicLit{Kind: token.STRING, Value: `"from error"`},
+					},
+				},
+			},
+		}
+		curr = newCall
+		msg = &ast.BasicLit{Kind: token.STRING, Value: `""`}
+	}
+
+	// Add .Msg(msg)
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: curr, Sel: ast.NewIdent("Msg")},
+		Args: []ast.Expr{msg},
 	}
-	curr := base
+}
 
-	// Add fields
+// addFields appends the zerolog equivalent of each zap field to the chain
+// curr. Fields built by helpers are expanded with the matching template.
+// Callers check the fields with fieldProblem first; fields it rejects
+// are left out.
+func addFields(curr ast.Expr, fields []ast.Expr, templates []*fieldTemplate) ast.Expr {
 	for _, field := range fields {
 		fcall, ok := field.(*ast.CallExpr)
 		if !ok {
 			continue
 		}
+		if t := findTemplate(templates, fcall); t != nil {
+			if next, err := t.apply(curr, fcall); err == nil {
+				curr = next
+			}
+			continue
+		}
 		fsel, ok := fcall.Fun.(*ast.SelectorExpr)
 		if !ok {
 			continue
@@ -389,7 +860,6 @@ func createZerologCall(level string, args []ast.Expr, recv string) ast.Expr {
 		zapType := fsel.Sel.Name
 		zeroType, ok := zapToZero[zapType]
 		if !ok {
-			fmt.Printf("Skipping unknown zap field type: %s\n", zapType)
 			continue
 		}
 
@@ -411,7 +881,9 @@ func createZerologCall(level string, args []ast.Expr, recv string) ast.Expr {
 				},
 			}
 		} else {
-			args = fcall.Args
+			// Copied so that normalizing the keys of the chain leaves the
+			// zap call as written.
+			args = append([]ast.Expr(nil), fcall.Args...)
 		}
 
 		newCall := &ast.CallExpr{
@@ -420,100 +892,63 @@ func createZerologCall(level string, args []ast.Expr, recv string) ast.Expr {
 		}
 		curr = newCall
 	}
-
-	// If msg was err.Error(), add .Err() and set msg to ""
-	if isErrMsg {
-		newCall := &ast.CallExpr{
-			Fun: &ast.SelectorExpr{X: curr, Sel: ast.NewIdent("Err")},
-			Args: []ast.Expr{
-				&ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   ast.NewIdent("errors"),
-						Sel: ast.NewIdent("Wrap"),
-					},
-					Args: []ast.Expr{
-						errExpr,
-						&ast.BasicLit{Kind: token.STRING, Value: `"from error"`},
-					},
-				},
-			},
-		}
-		curr = newCall
-		msg = &ast.BasicLit{Kind: token.STRING, Value: `""`}
-	}
-
-	// Add .Msg(msg)
-	return &ast.CallExpr{
-		Fun:  &ast.SelectorExpr{X: curr, Sel: ast.NewIdent("Msg")},
-		Args: []ast.Expr{msg},
-	}
+	return curr
 }
 
-func isImportPresent(f *ast.File, path string) bool {
-	for _, imp := range f.Imports {
-		if imp.Path.Value == `"`+path+`"` {
-			return true
-		}
+// logFields returns the fields of a logging call's arguments, after the
+// message.
+func logFields(args []ast.Expr) []ast.Expr {
+	if len(args) == 0 {
+		return nil
 	}
-	return false
+	return args[1:]
 }
 
-func addImport(f *ast.File, path, name string) {
-	imp := &ast.ImportSpec{
-		Path: &ast.BasicLit{Kind: token.STRING, Value: `"` + path + `"`},
+// fieldProblem returns why addFields cannot rewrite the zap field f, or
+// "" if it can.
+func fieldProblem(f ast.Expr, templates []*fieldTemplate) string {
+	call, ok := f.(*ast.CallExpr)
+	if !ok {
+		return "is not a call of a zap field constructor"
 	}
-	if name != "" {
-		imp.Name = ast.NewIdent(name)
+	if call.Ellipsis.IsValid() {
+		return "spreads its arguments"
 	}
-
-	var importDecl *ast.GenDecl
-	for _, decl := range f.Decls {
-		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
-			importDecl = gd
-			break
+	if t := findTemplate(templates, call); t != nil {
+		if err := t.checkArgs(call); err != nil {
+			return err.Error()
 		}
+		return ""
 	}
-	if importDecl == nil {
-		importDecl = &ast.GenDecl{Tok: token.IMPORT, Specs: []ast.Spec{}}
-		f.Decls = append([]ast.Decl{importDecl}, f.Decls...)
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !isIdent(sel.X, "zap") {
+		return "is not a call of a zap field constructor"
 	}
-	importDecl.Specs = append(importDecl.Specs, imp)
-}
-
-func removeImport(f *ast.File, path string) {
-	newDecls := []ast.Decl{}
-	for _, decl := range f.Decls {
-		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
-			newSpecs := []ast.Spec{}
-			for _, spec := range gd.Specs {
-				if imp, ok := spec.(*ast.ImportSpec); ok && imp.Path.Value != `"`+path+`"` {
-					newSpecs = append(newSpecs, spec)
-				}
-			}
-			if len(newSpecs) > 0 {
-				gd.Specs = newSpecs
-				newDecls = append(newDecls, gd)
-			}
-		} else {
-			newDecls = append(newDecls, decl)
-		}
+	if _, ok := zapToZero[sel.Sel.Name]; !ok {
+		return "has no zerolog equivalent the migrator knows"
+	}
+	if sel.Sel.Name == "Error" && len(call.Args) != 1 {
+		return "does not take one error"
 	}
-	f.Decls = newDecls
+	return ""
 }
 
-func usesZap(f *ast.File) bool {
-	used := false
-	ast.Inspect(f, func(n ast.Node) bool {
-		if used {
-			return false
-		}
-		if sel, ok := n.(*ast.SelectorExpr); ok {
-			if ident, ok := sel.X.(*ast.Ident); ok && ident.Name == "zap" {
-				used = true
-				return false
+// checkFields reports whether every field of the log call x can be
+// rewritten. If one cannot, the call is reported at orig to be left as
+// is, rather than rewritten without the field.
+func (rw *rewriter) checkFields(x *ast.CallExpr, orig ast.Expr, fields []ast.Expr) bool {
+	for _, f := range fields {
+		if why := fieldProblem(f, rw.cfg.FieldTemplates); why != "" {
+			sites := &rw.report.fields
+			if call, ok := f.(*ast.CallExpr); ok && findTemplate(rw.cfg.FieldTemplates, call) != nil {
+				sites = &rw.report.templates
 			}
+			*sites = append(*sites, site{
+				pos:  rw.fset.Position(orig.Pos()),
+				call: fmt.Sprintf("%s: %s %s", nodeString(rw.fset, x.Fun), nodeString(rw.fset, f), why),
+			})
+			return false
 		}
-		return true
-	})
-	return used
+	}
+	return true
 }
@@ -0,0 +1,190 @@
+package ast2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// cache stores the result of rewriting a file under a key derived from
+// its content, the tool binary and the settings of the run, so that runs
+// repeated while tuning the config skip files that did not change.
+type cache struct {
+	dir      string
+	settings string            // hash of the tool binary and the run settings
+	dirs     map[string]string // hash of the Go files of a directory, by directory
+
+	hits, misses, uncached int
+}
+
+// cacheEntry is the cached result of processSource for one file.
+type cacheEntry struct {
+	Out       []byte                 `json:"out,omitempty"` // nil if the file is unchanged
+	HelperPkg string                 `json:"helperPkg,omitempty"`
+	Report    map[string][]cacheSite `json:"report,omitempty"`
+	FieldKeys []string               `json:"fieldKeys,omitempty"` // receiver type of each loggerFields site
+}
+
+type cacheSite struct {
+	Pos  token.Position `json:"pos"`
+	Call string         `json:"call"`
+}
+
+// openCache prepares the cache in dir for a run with cfg and rules.
+func openCache(dir string, cfg *config, rules []byte) (*cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache: %w", err)
+	}
+	h := sha256.New()
+	exe, err := os.Executable()
+	if err == nil {
+		err = hashFile(h, exe)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hashing tool binary: %w", err)
+	}
+	settings, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(h, "\x00%s\x00%t\x00%t\x00%t\x00", settings, cfg.linesOnly, cfg.fields.add, cfg.types != nil)
+	h.Write(rules)
+	return &cache{dir: dir, settings: hex.EncodeToString(h.Sum(nil)), dirs: make(map[string]string)}, nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// key returns the cache key of the file at path with content src. The
+// other Go files of the directory are part of it, since receiver logger
+// fields are looked up across the package, and so is whether cfg rewrites
+// them, since a zap.Any field of a type declared there logs as an object
+// only if its marshaler is converted. The module config overrides are
+// part of it too. Types from other packages, used with -types, are not.
+func (c *cache) key(path string, src []byte, lines map[int]bool, changed bool, cfg *config) string {
+	abs, _ := filepath.Abs(path)
+	dir := filepath.Dir(abs)
+	if _, ok := c.dirs[dir]; !ok {
+		h := sha256.New()
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		for _, m := range matches {
+			if m != abs {
+				fmt.Fprintf(h, "%s\x00%t\x00", m, cfg.rewrites(m))
+				hashFile(h, m)
+			}
+		}
+		c.dirs[dir] = hex.EncodeToString(h.Sum(nil))
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%t\x00%s\x00", c.settings, c.dirs[dir], abs, changed, cfg.overrides)
+	nums := make([]int, 0, len(lines))
+	for l := range lines {
+		nums = append(nums, l)
+	}
+	sort.Ints(nums)
+	fmt.Fprintf(h, "%v\x00", nums)
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *cache) file(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *cache) load(key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(c.file(key))
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+func (c *cache) store(key string, e *cacheEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := c.file(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Concurrent runs never read a partial entry, nor write through the
+	// same temporary file.
+	return writeFileAtomic(path, data)
+}
+
+// processCached is processSource behind the cache of the run, if any.
+// Files whose rewrite depended on other files of the run, by adding a
+// logger field there or getting one from there, and files that need the
+// level helper written next to them are not cached.
+func processCached(path string, src []byte, cfg *config, rep *report) ([]byte, string, error) {
+	c := cfg.cache
+	if c == nil {
+		return processSource(path, src, cfg, rep)
+	}
+	abs, _ := filepath.Abs(path)
+	lines, changed := cfg.changedLines(path)
+	if !cfg.linesOnly {
+		lines = nil
+	}
+	key := c.key(path, src, lines, changed, cfg)
+	if e, ok := c.load(key); ok {
+		c.hits++
+		e.replay(cfg, rep)
+		return e.Out, e.HelperPkg, nil
+	}
+
+	plan := cfg.fields
+	added, pending := len(plan.added), len(plan.pending[abs])
+	issues, levelHelpers := len(plan.issues), cfg.levelHelpers
+	fileRep := &report{}
+	out, helperPkg, err := processSource(path, src, cfg, fileRep)
+	rep.merge(fileRep)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(plan.added) != added || pending > 0 || cfg.levelHelpers != levelHelpers {
+		c.uncached++
+		return out, helperPkg, nil
+	}
+	c.misses++
+	e := &cacheEntry{Out: out, HelperPkg: helperPkg, Report: fileRep.sites(), FieldKeys: plan.issues[issues:]}
+	if err := c.store(key, e); err != nil {
+		rep.fileError(path, fmt.Errorf("writing cache entry: %w", err))
+	}
+	return out, helperPkg, nil
+}
+
+// replay adds the cached report of the file to rep. Receiver types
+// already reported by another file of the run are left out.
+func (e *cacheEntry) replay(cfg *config, rep *report) {
+	fileRep := &report{}
+	fileRep.setSites(e.Report)
+	sites := fileRep.loggerFields
+	fileRep.loggerFields = nil
+	for i, k := range e.FieldKeys {
+		if i < len(sites) && !cfg.fields.reported[k] {
+			cfg.fields.reported[k] = true
+			cfg.fields.issues = append(cfg.fields.issues, k)
+			fileRep.loggerFields = append(fileRep.loggerFields, sites[i])
+		}
+	}
+	rep.merge(fileRep)
+}
@@ -0,0 +1,92 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.go")
+	other := filepath.Join(dir, "b.go")
+	src := []byte("package p\n")
+	write := func(data string) {
+		if err := os.WriteFile(other, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("package p\n")
+
+	// key returns the key of a.go after change has been applied to a
+	// fresh run.
+	key := func(change func(cfg *config, src *[]byte, lines *map[int]bool)) string {
+		cfg := defaultConfig()
+		c := &cache{dir: t.TempDir(), settings: "s", dirs: make(map[string]string)}
+		s, lines := src, map[int]bool(nil)
+		if change != nil {
+			change(cfg, &s, &lines)
+		}
+		return c.key(path, s, lines, false, cfg)
+	}
+	base := key(nil)
+	tests := []struct {
+		name   string
+		change func(cfg *config, src *[]byte, lines *map[int]bool)
+		same   bool
+	}{{
+		name:   "nothing",
+		change: func(*config, *[]byte, *map[int]bool) {},
+		same:   true,
+	}, {
+		name:   "source",
+		change: func(_ *config, src *[]byte, _ *map[int]bool) { *src = []byte("package q\n") },
+	}, {
+		name:   "lines",
+		change: func(_ *config, _ *[]byte, lines *map[int]bool) { *lines = map[int]bool{3: true} },
+	}, {
+		name:   "module config",
+		change: func(cfg *config, _ *[]byte, _ *map[int]bool) { cfg.overrides = "o" },
+	}, {
+		name:   "single file",
+		change: func(cfg *config, _ *[]byte, _ *map[int]bool) { cfg.single = true },
+	}, {
+		name: "other file not changed",
+		change: func(cfg *config, _ *[]byte, _ *map[int]bool) {
+			cfg.changed = map[string]map[int]bool{realPath(path): nil}
+		},
+	}, {
+		name:   "other file",
+		change: func(*config, *[]byte, *map[int]bool) { write("package p\n\nvar x int\n") },
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer write("package p\n")
+			if got := key(tt.change); (got == base) != tt.same {
+				t.Errorf("key changed: %t, want %t", got != base, !tt.same)
+			}
+		})
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	c := &cache{dir: t.TempDir(), dirs: make(map[string]string)}
+	key := "0123456789abcdef"
+	want := &cacheEntry{Out: []byte("package p\n"), HelperPkg: "p"}
+	for i := 0; i < 2; i++ {
+		if err := c.store(key, want); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, ok := c.load(key)
+	if !ok || string(got.Out) != string(want.Out) || got.HelperPkg != want.HelperPkg {
+		t.Errorf("load = %+v, %t, want %+v", got, ok, want)
+	}
+	entries, err := os.ReadDir(filepath.Dir(c.file(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("cache directory holds %d files, want the entry alone", len(entries))
+	}
+}
@@ -0,0 +1,155 @@
+package ast2
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"path/filepath"
+)
+
+// config holds the settings of a migration run. The exported fields can be
+// loaded from a JSON file with -config; the rest come from flags.
+type config struct {
+	// KeyRenames maps field keys found at zap call sites to the key the
+	// zerolog chain should use, e.g. {"uid": "user_id"}.
+	KeyRenames map[string]string `json:"keyRenames"`
+	// KeyCase is applied to keys that have no rename: "snake", "camel" or
+	// "" to keep them as written.
+	KeyCase string `json:"keyCase"`
+	// ErrorFieldName is the zerolog.ErrorFieldName of the migrated code.
+	// zap.Error fields whose normalized key differs from it are written
+	// with AnErr.
+	ErrorFieldName string `json:"errorFieldName"`
+	// FieldTemplates expand team helpers that return a zap.Field. A call
+	// whose argument has side effects is left as is if the replacement
+	// repeats its placeholder.
+	FieldTemplates []*fieldTemplate `json:"fieldTemplates"`
+	// SyncCall replaces the Sync calls of zap loggers, e.g.
+	// "logWriter.Flush()" for a buffered writer; they are removed if it is
+	// empty. The file must already import the packages it refers to.
+	SyncCall string `json:"syncCall"`
+	// LevelLogger is the zerolog.Logger whose Level replaces a
+	// zap.AtomicLevel, e.g. "log.Logger"; zerolog's global level does if
+	// it is empty.
+	LevelLogger string `json:"levelLogger"`
+
+	write     func(path string, data []byte) error // receives the rewritten and created files
+	written   map[string][]byte                    // what write received, by absolute path
+	review    *reviewer                            // set in -interactive mode
+	changed   map[string]map[int]bool              // with -since or -staged, changed lines per absolute path
+	linesOnly bool
+	rules     *ruleSet // set with -rules
+	fields    *fieldPlan
+	cache     *cache             // set with -cache-dir
+	types     *typeLoader        // set with -types
+	verify    *verifier          // set with -verify
+	workspace []*workspaceModule // modules of a go.work run
+	single    bool               // only one file is rewritten, with -file or Migrate
+	overrides string             // hash of the module config applied on top
+
+	syncCall     *ast.CallExpr // parsed SyncCall
+	levelLogger  ast.Expr      // parsed LevelLogger
+	levelHelpers int           // files that asked for the level helper
+}
+
+func defaultConfig() *config {
+	return &config{ErrorFieldName: "error", fields: newFieldPlan(), written: make(map[string][]byte)}
+}
+
+// output passes the new content of the file at path to cfg.write.
+func (cfg *config) output(path string, data []byte) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	cfg.written[abs] = data
+	if cfg.write == nil {
+		return nil
+	}
+	return cfg.write(path, data)
+}
+
+// rewrites reports whether the run rewrites the file at the absolute
+// path, when it walks the directory of the file.
+func (cfg *config) rewrites(path string) bool {
+	if cfg.single {
+		return false
+	}
+	if cfg.changed != nil {
+		_, ok := cfg.changedLines(path)
+		return ok
+	}
+	return true
+}
+
+// changedLines returns the lines of the file at path that changed, with
+// -since or -staged, and whether the file changed at all.
+func (cfg *config) changedLines(path string) (map[int]bool, bool) {
+	lines, ok := cfg.changed[realPath(path)]
+	return lines, ok
+}
+
+// parseConfig parses a JSON config on top of the defaults. path names it
+// in errors.
+func parseConfig(path string, data []byte) (*config, error) {
+	cfg := defaultConfig()
+	if data == nil {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := cfg.compile(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// compile checks the exported settings of cfg and parses the expressions
+// and templates among them. path names the config in errors.
+func (cfg *config) compile(path string) error {
+	switch cfg.KeyCase {
+	case "", "snake", "camel":
+	default:
+		return fmt.Errorf("config %s: unknown keyCase %q", path, cfg.KeyCase)
+	}
+	for i, t := range cfg.FieldTemplates {
+		if err := t.compile(); err != nil {
+			return fmt.Errorf("config %s: fieldTemplates[%d]: %w", path, i, err)
+		}
+	}
+	cfg.syncCall, cfg.levelLogger = nil, nil
+	if cfg.SyncCall != "" {
+		e, err := parseConfigExpr(cfg.SyncCall)
+		call, ok := e.(*ast.CallExpr)
+		if err == nil && !ok {
+			err = fmt.Errorf("%s is not a call", cfg.SyncCall)
+		}
+		if err != nil {
+			return fmt.Errorf("config %s: syncCall: %w", path, err)
+		}
+		cfg.syncCall = call
+	}
+	if cfg.LevelLogger != "" {
+		e, err := parseConfigExpr(cfg.LevelLogger)
+		if err != nil {
+			return fmt.Errorf("config %s: levelLogger: %w", path, err)
+		}
+		cfg.levelLogger = e
+	}
+	return nil
+}
+
+// parseConfigExpr parses an expression of the config and checks that the
+// rewrites can copy it.
+func parseConfigExpr(s string) (ast.Expr, error) {
+	e, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cloneExpr(e, nil); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
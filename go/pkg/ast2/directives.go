@@ -0,0 +1,102 @@
+package ast2
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+const directivePrefix = "//zapmigrate:"
+
+// directive returns the name of a //zapmigrate: directive comment, e.g.
+// "ignore" or "ignore-file", or "" if c is not a directive.
+func directive(c *ast.Comment) string {
+	if !strings.HasPrefix(c.Text, directivePrefix) {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(c.Text, directivePrefix))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// hasIgnoreFileDirective reports whether a //zapmigrate:ignore-file comment
+// appears before the package clause.
+func hasIgnoreFileDirective(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if directive(c) == "ignore-file" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ignoredLines returns the lines covered by //zapmigrate:ignore comments.
+// A trailing directive covers its own line, a directive on a line of its
+// own covers the line that follows it.
+func ignoredLines(fset *token.FileSet, f *ast.File, src []byte) map[int]bool {
+	lines := make(map[int]bool)
+	tf := fset.File(f.Pos())
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if directive(c) != "ignore" {
+				continue
+			}
+			line := tf.Line(c.Pos())
+			start := tf.Offset(tf.LineStart(line))
+			if strings.TrimSpace(string(src[start:tf.Offset(c.Pos())])) == "" {
+				line++
+			}
+			lines[line] = true
+		}
+	}
+	return lines
+}
+
+func (rw *rewriter) isIgnored(s ast.Stmt) bool {
+	return rw.isIgnoredPos(s.Pos())
+}
+
+func (rw *rewriter) isIgnoredPos(pos token.Pos) bool {
+	return rw.ignored[rw.fset.Position(pos).Line]
+}
+
+// ignoreNode records every site under n the migration would rewrite as
+// ignored: the logging calls of utils.Logger and of the global loggers,
+// zap.ReplaceGlobals, the Sync calls of zap loggers, AtomicLevels and
+// zapcore marshaler methods.
+func (rw *rewriter) ignoreNode(n ast.Node) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		var name string
+		switch x := n.(type) {
+		case *ast.FuncDecl:
+			if k := marshalerOf(x); k != nil {
+				name = recvTypeName(x) + "." + k.zapMethod
+			}
+		case *ast.CallExpr:
+			sel, ok := x.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			level, _ := globalLevel(sel)
+			if isUtilsLogger(sel.X) && logLevels[sel.Sel.Name] || level != "" || rw.zapSync(x) != nil ||
+				isReplaceGlobals(x) || isPkgCall(x, "zap", "NewAtomicLevel") || isPkgCall(x, "zap", "NewAtomicLevelAt") {
+				name = types.ExprString(x.Fun)
+			}
+		}
+		if name != "" {
+			rw.report.ignored = append(rw.report.ignored, site{
+				pos:  rw.fset.Position(n.Pos()),
+				call: name,
+			})
+		}
+		return true
+	})
+}
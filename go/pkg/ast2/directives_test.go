@@ -0,0 +1,47 @@
+package ast2
+
+import "testing"
+
+func TestDirectives(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "ignored statement",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	//zapmigrate:ignore
+	zap.L().Info("kept")
+	zap.L().Info("moved")
+}
+`,
+		want:  []string{`zap.L().Info("kept")`, `log.Info().Msg("moved")`},
+		diags: []string{CategoryIgnored},
+	}, {
+		name: "ignored file",
+		src: `//zapmigrate:ignore-file
+
+package p
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type T struct{}
+
+func (T) MarshalLogObject(enc zapcore.ObjectEncoder) error { return nil }
+
+func f(l *zap.Logger) {
+	zap.ReplaceGlobals(l)
+	atom := zap.NewAtomicLevel()
+	_ = atom
+	zap.S().Infof("kept %d", 1)
+	zap.L().Sync()
+}
+`,
+		want:    []string{"zap.ReplaceGlobals(l)", `zap.S().Infof("kept %d", 1)`},
+		notWant: []string{"MarshalZerologObject"},
+		diags:   []string{CategoryIgnored, CategoryIgnored, CategoryIgnored, CategoryIgnored, CategoryIgnored},
+	}})
+}
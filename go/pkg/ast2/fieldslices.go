@@ -0,0 +1,453 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// fieldSlice is a local []zap.Field variable that is built from known
+// fields and spread into log calls:
+//
+//	fields := []zap.Field{zap.String("k", v)}
+//	if n > 0 {
+//		fields = append(fields, zap.Int("n", n))
+//	}
+//	utils.Logger.Info(msg, fields...)
+//
+// Such a slice becomes a slice of zerolog hooks, and the log calls apply
+// them with Func:
+//
+//	fields := []func(*zerolog.Event){fieldHook(zap.String("k", v))}
+//	...
+//	s.logger.Info().Func(func(e *zerolog.Event) { for _, f := range fields { f(e) } }).Msg(msg)
+//
+// A slice is only converted if every log call spreading it is rewritten
+// too, so the calls are reviewed together with its declaration.
+type fieldSlice struct {
+	obj     *ast.Object
+	decl    ast.Stmt
+	elts    *[]ast.Expr // fields of the declaration, nil without any
+	appends []*ast.CallExpr
+	spreads []*ast.CallExpr // log calls spreading the slice
+	dynamic string          // why the slice cannot be converted
+}
+
+// convertFieldSlices converts the field slices of the function body b and
+// returns them by object. Slices that are built in ways the migrator does
+// not follow are reported when a log call spreads them.
+func (rw *rewriter) convertFieldSlices(b *ast.BlockStmt) map[*ast.Object]*fieldSlice {
+	slices := make(map[*ast.Object]*fieldSlice)
+	ast.Inspect(b, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			// fields := []zap.Field{...} or make([]zap.Field, ...)
+			if x.Tok != token.DEFINE || len(x.Lhs) != 1 || len(x.Rhs) != 1 {
+				return true
+			}
+			id, ok := x.Lhs[0].(*ast.Ident)
+			if ok && id.Obj != nil {
+				if elts, ok := fieldSliceValue(x.Rhs[0]); ok {
+					slices[id.Obj] = &fieldSlice{obj: id.Obj, decl: x, elts: elts}
+				}
+			}
+		case *ast.DeclStmt:
+			// var fields []zap.Field
+			gd, ok := x.Decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.VAR || len(gd.Specs) != 1 {
+				return true
+			}
+			vs := gd.Specs[0].(*ast.ValueSpec)
+			if len(vs.Names) != 1 || vs.Names[0].Obj == nil || len(vs.Values) > 1 {
+				return true
+			}
+			fs := &fieldSlice{obj: vs.Names[0].Obj, decl: x}
+			if len(vs.Values) == 1 {
+				elts, ok := fieldSliceValue(vs.Values[0])
+				if !ok || (vs.Type != nil && !isFieldSliceType(vs.Type)) {
+					return true
+				}
+				fs.elts = elts
+			} else if vs.Type == nil || !isFieldSliceType(vs.Type) {
+				return true
+			}
+			slices[fs.obj] = fs
+		}
+		return true
+	})
+	if len(slices) == 0 {
+		return nil
+	}
+
+	// Every use of a slice must be an append of known fields or a spread
+	// into a log call.
+	allowed := make(map[*ast.Ident]bool)
+	var markDynamic = func(fs *fieldSlice, why string) {
+		if fs.dynamic == "" {
+			fs.dynamic = why
+		}
+	}
+	ast.Inspect(b, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if fs, ok := sliceOf(slices, x.Lhs); ok && x.Tok == token.DEFINE && fs.decl == ast.Stmt(x) {
+				allowed[x.Lhs[0].(*ast.Ident)] = true
+				break
+			}
+			fs, ok := sliceOf(slices, x.Lhs)
+			if !ok || x.Tok != token.ASSIGN || len(x.Rhs) != 1 {
+				break
+			}
+			call, ok := x.Rhs[0].(*ast.CallExpr)
+			if !ok || !isIdent(call.Fun, "append") || len(call.Args) == 0 || !isIdent(call.Args[0], x.Lhs[0].(*ast.Ident).Name) {
+				break
+			}
+			if call.Ellipsis.IsValid() {
+				markDynamic(fs, "appends another slice")
+				break
+			}
+			if id := call.Args[0].(*ast.Ident); id.Obj != fs.obj {
+				break
+			}
+			allowed[x.Lhs[0].(*ast.Ident)] = true
+			allowed[call.Args[0].(*ast.Ident)] = true
+			fs.appends = append(fs.appends, call)
+		case *ast.DeclStmt:
+			if gd, ok := x.Decl.(*ast.GenDecl); ok && len(gd.Specs) == 1 {
+				if vs, ok := gd.Specs[0].(*ast.ValueSpec); ok && len(vs.Names) == 1 {
+					if fs, ok := slices[vs.Names[0].Obj]; ok && fs.decl == ast.Stmt(x) {
+						allowed[vs.Names[0]] = true
+					}
+				}
+			}
+		case *ast.CallExpr:
+			if id, ok := spreadArg(x); ok && isLogCall(x) {
+				if fs, ok := slices[id.Obj]; ok {
+					allowed[id] = true
+					fs.spreads = append(fs.spreads, x)
+					if rw.isIgnoredPos(x.Pos()) {
+						markDynamic(fs, "is spread into an ignored call")
+					}
+				}
+			}
+		case *ast.Ident:
+			if fs, ok := slices[x.Obj]; ok && !allowed[x] {
+				markDynamic(fs, "is used other than to append fields and spread them into log calls")
+			}
+		}
+		return true
+	})
+
+	for _, fs := range slices {
+		if fs.dynamic != "" || len(fs.spreads) == 0 {
+			continue
+		}
+		for _, call := range fs.spreads {
+			if isUtilsLogger(call.Fun.(*ast.SelectorExpr).X) && rw.loggerPath == nil {
+				markDynamic(fs, "is spread into a utils.Logger call without a receiver logger to rewrite to")
+			}
+		}
+		var fields []ast.Expr
+		if fs.elts != nil {
+			fields = append(fields, *fs.elts...)
+		}
+		for _, call := range fs.appends {
+			fields = append(fields, call.Args[1:]...)
+		}
+		for _, f := range fields {
+			if !rw.knownField(f) {
+				markDynamic(fs, fmt.Sprintf("holds %s, which the migrator does not know", nodeString(rw.fset, f)))
+				break
+			}
+		}
+		if fs.dynamic != "" {
+			continue
+		}
+		if rw.lines != nil && !rw.touchesChangedLine(fs.decl) {
+			fs.dynamic = "is declared outside the changed lines"
+			continue
+		}
+		if !rw.accept(fs.decl, rw.convertedDecl(fs, true)) {
+			fs.dynamic = "was not accepted"
+			continue
+		}
+		for _, call := range fs.spreads {
+			if !rw.accept(call, rw.spreadChain(call)) {
+				fs.dynamic = "is spread into a call that was not accepted"
+				break
+			}
+		}
+		if fs.dynamic != "" {
+			continue
+		}
+		rw.convertedDecl(fs, false)
+		for _, call := range fs.appends {
+			for i, f := range call.Args[1:] {
+				call.Args[i+1] = rw.fieldHook(f)
+			}
+		}
+		rw.rewritten++
+	}
+	return slices
+}
+
+// convertedDecl returns the declaration of fs as a slice of hooks. With
+// preview set the declaration is left alone and a copy is returned.
+func (rw *rewriter) convertedDecl(fs *fieldSlice, preview bool) ast.Stmt {
+	var elts []ast.Expr
+	if fs.elts != nil {
+		for _, f := range *fs.elts {
+			elts = append(elts, rw.fieldHook(f))
+		}
+	}
+	switch d := fs.decl.(type) {
+	case *ast.AssignStmt:
+		rhs := hookSliceValue(d.Rhs[0], elts)
+		if preview {
+			return &ast.AssignStmt{Lhs: d.Lhs, Tok: d.Tok, Rhs: []ast.Expr{rhs}}
+		}
+		d.Rhs[0] = rhs
+	case *ast.DeclStmt:
+		vs := d.Decl.(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
+		typ := vs.Type
+		if typ != nil {
+			typ = hookSliceType()
+		}
+		var values []ast.Expr
+		if len(vs.Values) == 1 {
+			values = []ast.Expr{hookSliceValue(vs.Values[0], elts)}
+		}
+		if preview {
+			spec := &ast.ValueSpec{Names: vs.Names, Type: typ, Values: values}
+			return &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{spec}}}
+		}
+		vs.Type, vs.Values = typ, values
+	}
+	return fs.decl
+}
+
+// fieldSliceValue reports whether e is []zap.Field{...} or
+// make([]zap.Field, ...), and returns the elements of the former.
+func fieldSliceValue(e ast.Expr) (*[]ast.Expr, bool) {
+	switch x := e.(type) {
+	case *ast.CompositeLit:
+		if isFieldSliceType(x.Type) {
+			return &x.Elts, true
+		}
+	case *ast.CallExpr:
+		if isIdent(x.Fun, "make") && len(x.Args) > 0 && isFieldSliceType(x.Args[0]) {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func hookSliceValue(e ast.Expr, elts []ast.Expr) ast.Expr {
+	switch x := e.(type) {
+	case *ast.CompositeLit:
+		return &ast.CompositeLit{Type: hookSliceType(), Lbrace: x.Lbrace, Elts: elts, Rbrace: x.Rbrace}
+	case *ast.CallExpr:
+		args := append([]ast.Expr{hookSliceType()}, x.Args[1:]...)
+		return &ast.CallExpr{Fun: x.Fun, Args: args}
+	}
+	return e
+}
+
+func isFieldSliceType(e ast.Expr) bool {
+	at, ok := e.(*ast.ArrayType)
+	if !ok || at.Len != nil {
+		return false
+	}
+	sel, ok := at.Elt.(*ast.SelectorExpr)
+	return ok && (isIdent(sel.X, "zap") || isIdent(sel.X, "zapcore")) && sel.Sel.Name == "Field"
+}
+
+// hookSliceType returns []func(*zerolog.Event).
+func hookSliceType() ast.Expr {
+	return &ast.ArrayType{Elt: &ast.FuncType{Params: &ast.FieldList{List: []*ast.Field{
+		{Type: &ast.StarExpr{X: selector("zerolog", "Event")}},
+	}}}}
+}
+
+// hookType returns func(name *zerolog.Event).
+func hookType(name string) *ast.FuncType {
+	return &ast.FuncType{Params: &ast.FieldList{List: []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent(name)}, Type: &ast.StarExpr{X: selector("zerolog", "Event")}},
+	}}}
+}
+
+// fieldHook returns a zerolog hook that adds the zap field f to an event.
+// zap evaluates the values of a field when the field is made, so the hook
+// captures copies of them taken at that point rather than the variables:
+//
+//	func() func(*zerolog.Event) { v := n; return func(e *zerolog.Event) { e.Int("n", v) } }()
+//
+// Literal values need no copy; a field of literals is a plain hook.
+func (rw *rewriter) fieldHook(f ast.Expr) ast.Expr {
+	name := freeName(f, "e", "ev", "event")
+	call := f.(*ast.CallExpr)
+	field := &ast.CallExpr{Fun: call.Fun, Lparen: call.Lparen, Args: append([]ast.Expr(nil), call.Args...), Rparen: call.Rparen}
+	var copies []ast.Stmt
+	for i, arg := range field.Args {
+		if isLiteral(arg) {
+			continue
+		}
+		n := ""
+		if len(copies) > 0 {
+			n = fmt.Sprint(len(copies) + 1)
+		}
+		v := ast.NewIdent(freeName(f, "v"+n, "val"+n, "value"+n))
+		copies = append(copies, &ast.AssignStmt{Lhs: []ast.Expr{v}, Tok: token.DEFINE, Rhs: []ast.Expr{arg}})
+		field.Args[i] = ast.NewIdent(v.Name)
+	}
+	chain := addFields(ast.NewIdent(name), []ast.Expr{field}, rw.cfg.FieldTemplates)
+	rw.normalizeChain(chain)
+	typ := hookType(name)
+	hook := &ast.FuncLit{
+		Type: typ,
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: chain}}},
+	}
+	if len(copies) == 0 {
+		typ.Func = f.Pos()
+		hook.Body.Lbrace, hook.Body.Rbrace = f.Pos(), f.End()
+		return hook
+	}
+	maker := &ast.FuncLit{
+		Type: &ast.FuncType{Func: f.Pos(), Params: &ast.FieldList{}, Results: &ast.FieldList{List: []*ast.Field{{Type: hookSliceType().(*ast.ArrayType).Elt}}}},
+		Body: &ast.BlockStmt{Lbrace: f.Pos(), List: append(copies, &ast.ReturnStmt{Results: []ast.Expr{hook}}), Rbrace: f.End()},
+	}
+	return &ast.CallExpr{Fun: maker}
+}
+
+// isLiteral reports whether e is a literal or a predeclared constant,
+// whose value cannot change.
+func isLiteral(e ast.Expr) bool {
+	switch x := e.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		return x.Name == "true" || x.Name == "false" || x.Name == "nil"
+	}
+	return false
+}
+
+// applyHooks returns the Func hook that applies the hooks in slice:
+//
+//	func(e *zerolog.Event) { for _, f := range slice { f(e) } }
+func applyHooks(slice *ast.Ident) ast.Expr {
+	e := freeName(slice, "e", "ev", "event")
+	f := freeName(slice, "f", "fn", "hook")
+	return &ast.FuncLit{
+		Type: hookType(e),
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.RangeStmt{
+			Key:   ast.NewIdent("_"),
+			Value: ast.NewIdent(f),
+			Tok:   token.DEFINE,
+			X:     ast.NewIdent(slice.Name),
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{
+				Fun:  ast.NewIdent(f),
+				Args: []ast.Expr{ast.NewIdent(e)},
+			}}}},
+		}}},
+	}
+}
+
+// freeName returns the first of names that is not an identifier in n.
+func freeName(n ast.Node, names ...string) string {
+	used := make(map[string]bool)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			used[id.Name] = true
+		}
+		return true
+	})
+	for _, name := range names {
+		if !used[name] {
+			return name
+		}
+	}
+	return names[len(names)-1] + "_"
+}
+
+// knownField reports whether f is a zap field the migrator can rewrite.
+func (rw *rewriter) knownField(f ast.Expr) bool {
+	return fieldProblem(f, rw.cfg.FieldTemplates) == ""
+}
+
+func sliceOf(slices map[*ast.Object]*fieldSlice, lhs []ast.Expr) (*fieldSlice, bool) {
+	if len(lhs) != 1 {
+		return nil, false
+	}
+	id, ok := lhs[0].(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	fs, ok := slices[id.Obj]
+	return fs, ok
+}
+
+// spreadArg returns the identifier spread into call, as in f(msg, fields...).
+func spreadArg(call *ast.CallExpr) (*ast.Ident, bool) {
+	if !call.Ellipsis.IsValid() || len(call.Args) == 0 {
+		return nil, false
+	}
+	id, ok := call.Args[len(call.Args)-1].(*ast.Ident)
+	return id, ok
+}
+
+// isLogCall reports whether call is a zap logging call that takes fields.
+func isLogCall(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	if isUtilsLogger(sel.X) && logLevels[sel.Sel.Name] {
+		return true
+	}
+	level, _ := globalLevel(sel)
+	return level != "" && isPkgCall(sel.X.(*ast.CallExpr), "zap", "L")
+}
+
+// spreadFields rewrites a log call that spreads a field slice, or reports
+// it and returns nil when the slice was not converted. The call was
+// reviewed with the slice by convertFieldSlices.
+func (rw *rewriter) spreadFields(x *ast.CallExpr) ast.Expr {
+	id, ok := spreadArg(x)
+	var fs *fieldSlice
+	if ok {
+		fs = rw.slices[id.Obj]
+	}
+	if fs == nil || fs.dynamic != "" {
+		why := "is not a local []zap.Field"
+		if fs != nil {
+			why = fs.dynamic
+		}
+		rw.report.dynamicFields = append(rw.report.dynamicFields, site{
+			pos:  rw.fset.Position(x.Pos()),
+			call: fmt.Sprintf("%s: %s %s", nodeString(rw.fset, x.Fun), nodeString(rw.fset, x.Args[len(x.Args)-1]), why),
+		})
+		return nil
+	}
+	return rw.spreadChain(x)
+}
+
+// spreadChain returns the zerolog chain of the log call x, which spreads
+// a field slice converted to hooks.
+func (rw *rewriter) spreadChain(x *ast.CallExpr) ast.Expr {
+	sel := x.Fun.(*ast.SelectorExpr)
+	id, _ := spreadArg(x)
+	args := x.Args[:len(x.Args)-1]
+	var chain ast.Expr
+	if isUtilsLogger(sel.X) {
+		chain = createZerologCall(sel.Sel.Name, args, loggerSelector(rw.recv, rw.loggerPath), rw.cfg.FieldTemplates)
+	} else {
+		chain = zerologChain(ast.NewIdent("log"), sel.Sel.Name, args, rw.cfg.FieldTemplates)
+	}
+	msg := chain.(*ast.CallExpr).Fun.(*ast.SelectorExpr)
+	msg.X = &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: msg.X, Sel: ast.NewIdent("Func")},
+		Args: []ast.Expr{applyHooks(id)},
+	}
+	rw.normalizeChain(chain)
+	return chain
+}
@@ -0,0 +1,91 @@
+package ast2
+
+import "testing"
+
+const fieldSliceHeader = `package p
+
+import (
+	"github.com/rs/zerolog"
+	"go.uber.org/zap"
+	"utils"
+)
+
+type S struct{ logger zerolog.Logger }
+
+type N struct{}
+`
+
+func TestFieldSlices(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "converted",
+		src: fieldSliceHeader + `
+func (s *S) A(id string, n int) {
+	fields := []zap.Field{zap.String("id", id)}
+	if n > 0 {
+		fields = append(fields, zap.Int("n", n), zap.Bool("ok", true))
+	}
+	utils.Logger.Info("hi", fields...)
+}
+`,
+		want: []string{
+			"fields := []func(*zerolog.Event){",
+			"s.logger.Info().Func(",
+			`e.Bool("ok", true)`,
+		},
+	}, {
+		// The hook logs the value the variable had when the field was
+		// appended, as zap does.
+		name: "values copied when appended",
+		src: fieldSliceHeader + `
+func (s *S) A(n int) {
+	var fields []zap.Field
+	fields = append(fields, zap.Int("n", n))
+	n++
+	utils.Logger.Info("hi", fields...)
+}
+`,
+		want: []string{"v := n", `e.Int("n", v)`},
+	}, {
+		name: "receiver without logger",
+		src: fieldSliceHeader + `
+func (s *N) B(id string) {
+	fields := []zap.Field{zap.String("id", id)}
+	utils.Logger.Info("hi", fields...)
+}
+`,
+		want:    []string{"fields := []zap.Field{", `utils.Logger.Info("hi", fields...)`},
+		notWant: []string{"zerolog.Event"},
+		diags:   []string{CategoryDynamicFields, CategoryLoggerField},
+	}, {
+		name: "plain function",
+		src: fieldSliceHeader + `
+func C(id string) {
+	fields := []zap.Field{zap.String("id", id)}
+	utils.Logger.Info("hi", fields...)
+}
+`,
+		want:    []string{"fields := []zap.Field{", `"go.uber.org/zap"`},
+		notWant: []string{"zerolog.Event"},
+		diags:   []string{CategoryDynamicFields},
+	}, {
+		name: "global logger in plain function",
+		src: fieldSliceHeader + `
+func D(id string) {
+	fields := []zap.Field{zap.String("id", id)}
+	zap.L().Info("hi", fields...)
+}
+`,
+		want: []string{"log.Info().Func(", "v := id"},
+	}, {
+		name: "used otherwise",
+		src: fieldSliceHeader + `
+func (s *S) E(id string) {
+	fields := []zap.Field{zap.String("id", id)}
+	keep(fields)
+	utils.Logger.Info("hi", fields...)
+}
+`,
+		notWant: []string{"zerolog.Event"},
+		diags:   []string{CategoryDynamicFields},
+	}})
+}
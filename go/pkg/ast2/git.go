@@ -0,0 +1,171 @@
+package ast2
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// changedLines asks the git repository containing dir which Go files
+// changed relative to ref, or in the index when staged is set, and which
+// lines of the new version each diff hunk touches. Untracked files count
+// as changed throughout unless staged is set. Keys are absolute paths with
+// symbolic links resolved, as realPath returns them.
+func changedLines(dir, ref string, staged bool) (map[string]map[int]bool, error) {
+	top, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root := realPath(strings.TrimSpace(string(top)))
+
+	// The prefixes and quoting are fixed whatever the user's config says,
+	// for parseDiff.
+	args := []string{"-c", "core.quotePath=false", "diff", "-U0", "--no-color", "--no-ext-diff", "--diff-filter=ACMR", "--src-prefix=a/", "--dst-prefix=b/"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	if ref != "" {
+		args = append(args, ref)
+	}
+	args = append(args, "--", "*.go")
+	out, err := git(root, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := parseDiff(root, out)
+	if err != nil || staged {
+		return changed, err
+	}
+	untracked, err := git(root, "ls-files", "-z", "--others", "--exclude-standard", "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(string(untracked), "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(name))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		lines := make(map[int]bool)
+		for l := 1; l <= bytes.Count(data, []byte("\n"))+1; l++ {
+			lines[l] = true
+		}
+		changed[path] = lines
+	}
+	return changed, nil
+}
+
+// parseDiff returns the lines of the new version touched by the hunks of
+// a -U0 diff, by absolute path under root.
+func parseDiff(root string, out []byte) (map[string]map[int]bool, error) {
+	changed := make(map[string]map[int]bool)
+	var lines map[int]bool
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			name, err := diffName(strings.TrimPrefix(line, "+++ "))
+			if err != nil {
+				return nil, err
+			}
+			if name == "/dev/null" {
+				lines = nil
+				continue
+			}
+			lines = make(map[int]bool)
+			changed[filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(name, "b/")))] = lines
+		case strings.HasPrefix(line, "@@ ") && lines != nil:
+			start, count, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			for l := start; l < start+count; l++ {
+				lines[l] = true
+			}
+		}
+	}
+	return changed, sc.Err()
+}
+
+// diffName returns the file name of a "+++" line: git ends names that
+// contain a space with a tab, and quotes those with a double quote,
+// backslash or control character even with core.quotePath off.
+func diffName(name string) (string, error) {
+	name = strings.TrimSuffix(name, "\t")
+	if !strings.HasPrefix(name, `"`) {
+		return name, nil
+	}
+	unquoted, err := strconv.Unquote(name)
+	if err != nil {
+		return "", fmt.Errorf("malformed file name %s in diff", name)
+	}
+	return unquoted, nil
+}
+
+// realPath returns the absolute path of path with symbolic links
+// resolved, or just the absolute path if they cannot be, so that paths
+// from git and from the command line compare equal.
+func realPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
+
+// parseHunkHeader returns the new-file range of a "@@ -a,b +c,d @@" line.
+func parseHunkHeader(line string) (start, count int, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, fmt.Errorf("malformed hunk header %q", line)
+	}
+	r := strings.TrimPrefix(fields[2], "+")
+	count = 1
+	if i := strings.IndexByte(r, ','); i >= 0 {
+		if count, err = strconv.Atoi(r[i+1:]); err != nil {
+			return 0, 0, fmt.Errorf("malformed hunk header %q", line)
+		}
+		r = r[:i]
+	}
+	if start, err = strconv.Atoi(r); err != nil {
+		return 0, 0, fmt.Errorf("malformed hunk header %q", line)
+	}
+	return start, count, nil
+}
+
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// touchesChangedLine reports whether n spans one of the lines allowed by
+// -lines-only.
+func (rw *rewriter) touchesChangedLine(n ast.Node) bool {
+	for l := rw.fset.Position(n.Pos()).Line; l <= rw.fset.Position(n.End()).Line; l++ {
+		if rw.lines[l] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package ast2
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseHunkHeader(t *testing.T) {
+	tests := []struct {
+		line         string
+		start, count int
+		err          bool
+	}{
+		{line: "@@ -1,2 +3,4 @@", start: 3, count: 4},
+		{line: "@@ -1 +7 @@ func f() {", start: 7, count: 1},
+		{line: "@@ -5,2 +4,0 @@", start: 4, count: 0},
+		{line: "@@ -1 @@", err: true},
+		{line: "@@ -1 +x,2 @@", err: true},
+		{line: "@@ -1 +2,y @@", err: true},
+	}
+	for _, tt := range tests {
+		start, count, err := parseHunkHeader(tt.line)
+		if (err != nil) != tt.err || start != tt.start || count != tt.count {
+			t.Errorf("parseHunkHeader(%q) = %d, %d, %v, want %d, %d, error %t", tt.line, start, count, err, tt.start, tt.count, tt.err)
+		}
+	}
+}
+
+func TestParseDiff(t *testing.T) {
+	const diff = `diff --git a/a.go b/a.go
+--- a/a.go
++++ b/a.go
+@@ -1 +2,2 @@
+diff --git a/x y.go b/x y.go
+--- a/x y.go	
++++ b/x y.go	
+@@ -3 +3 @@
+diff --git "a/q\"\303\251.go" "b/q\"\303\251.go"
+--- "a/q\"\303\251.go"
++++ "b/q\"\303\251.go"
+@@ -0,0 +1 @@
+`
+	changed, err := parseDiff("/r", []byte(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[int]bool{
+		filepath.FromSlash("/r/a.go"):         {2: true, 3: true},
+		filepath.FromSlash("/r/x y.go"):       {3: true},
+		filepath.FromSlash("/r/q\"\u00e9.go"): {1: true},
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("parseDiff = %v, want %v", changed, want)
+	}
+	if _, err := parseDiff("/r", []byte("+++ \"b/bad\n")); err == nil {
+		t.Error("parseDiff accepted an unterminated quoted name")
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo := realPath(t.TempDir())
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@t", "-C", repo}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	// Settings that change the names in the diff output.
+	run("config", "diff.mnemonicPrefix", "true")
+	run("config", "core.quotePath", "true")
+	write("a.go", "package p\n\nfunc a() {}\n")
+	write("é b.go", "package p\n")
+	write(".gitignore", "ignored.go\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+	write("a.go", "package p\n\nfunc a() {}\n\nfunc b() {}\n")
+	write("é b.go", "package p\n\nfunc d() {}\n")
+	write("new.go", "package p\n\nfunc c() {}")
+	write("ignored.go", "package p\n")
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(repo, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		staged bool
+		want   map[string]map[int]bool
+	}{{
+		name: "worktree",
+		want: map[string]map[int]bool{
+			"a.go":   {4: true, 5: true},
+			"é b.go": {2: true, 3: true},
+			"new.go": {1: true, 2: true, 3: true},
+		},
+	}, {
+		name:   "staged",
+		staged: true,
+		want:   map[string]map[int]bool{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The repository is reached through a symbolic link.
+			changed, err := changedLines(link, "", tt.staged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make(map[string]map[int]bool)
+			for name, lines := range tt.want {
+				want[filepath.Join(repo, name)] = lines
+			}
+			if !reflect.DeepEqual(changed, want) {
+				t.Errorf("changedLines = %v, want %v", changed, want)
+			}
+			cfg := defaultConfig()
+			cfg.changed = changed
+			if _, ok := cfg.changedLines(filepath.Join(link, "a.go")); ok != !tt.staged {
+				t.Errorf("a.go through the link changed: %t, want %t", ok, !tt.staged)
+			}
+		})
+	}
+}
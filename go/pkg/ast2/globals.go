@@ -0,0 +1,211 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// isGlobalLogger reports whether e is zap.L() or zap.S().
+func isGlobalLogger(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	return ok && len(call.Args) == 0 && (isPkgCall(call, "zap", "L") || isPkgCall(call, "zap", "S"))
+}
+
+// globalLevel returns the level of a zap.L() or zap.S() logging call and
+// the sugared suffix of the method ("", "f" or "w"), or "" if sel is not
+// one.
+func globalLevel(sel *ast.SelectorExpr) (level, suffix string) {
+	if !isGlobalLogger(sel.X) {
+		return "", ""
+	}
+	name := sel.Sel.Name
+	if logLevels[name] {
+		return name, ""
+	}
+	if !isPkgCall(sel.X.(*ast.CallExpr), "zap", "S") {
+		return "", ""
+	}
+	for _, suffix := range []string{"f", "w"} {
+		if level := strings.TrimSuffix(name, suffix); level != name && logLevels[level] {
+			return level, suffix
+		}
+	}
+	return "", ""
+}
+
+func isReplaceGlobals(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	return ok && len(call.Args) == 1 && isPkgCall(call, "zap", "ReplaceGlobals")
+}
+
+// createGlobalCall rewrites a zap.L() or zap.S() logging call to the
+// github.com/rs/zerolog/log package:
+//
+//	zap.L().Info(msg, fields...)  -> log.Info().Field(...).Msg(msg)
+//	zap.S().Infof(format, args...) -> log.Info().Msgf(format, args...)
+//	zap.S().Infow(msg, kvs...)     -> log.Info().Fields([]interface{}{kvs...}).Msg(msg)
+//	zap.S().Info(args...)          -> log.Info().Msg(fmt.Sprint(args...))
+func createGlobalCall(call *ast.CallExpr, templates []*fieldTemplate) ast.Expr {
+	sel := call.Fun.(*ast.SelectorExpr)
+	args := call.Args
+	level, suffix := globalLevel(sel)
+	logger := ast.NewIdent("log")
+	if isPkgCall(sel.X.(*ast.CallExpr), "zap", "L") {
+		return zerologChain(logger, level, args, templates)
+	}
+
+	event := &ast.CallExpr{Fun: &ast.SelectorExpr{X: logger, Sel: ast.NewIdent(level)}}
+	switch suffix {
+	case "f":
+		return &ast.CallExpr{
+			Fun:      &ast.SelectorExpr{X: event, Sel: ast.NewIdent("Msgf")},
+			Args:     args,
+			Ellipsis: call.Ellipsis,
+		}
+	case "w":
+		var msg ast.Expr = &ast.BasicLit{Kind: token.STRING, Value: `""`}
+		if len(args) > 0 {
+			msg, args = args[0], args[1:]
+		}
+		var curr ast.Expr = event
+		if call.Ellipsis.IsValid() && len(args) == 1 {
+			// Infow(msg, kvs...) passes the slice as is.
+			curr = &ast.CallExpr{
+				Fun:  &ast.SelectorExpr{X: event, Sel: ast.NewIdent("Fields")},
+				Args: args,
+			}
+		} else if len(args) > 0 {
+			curr = &ast.CallExpr{
+				Fun: &ast.SelectorExpr{X: event, Sel: ast.NewIdent("Fields")},
+				Args: []ast.Expr{&ast.CompositeLit{
+					Type: &ast.ArrayType{Elt: ast.NewIdent("interface{}")},
+					Elts: args,
+				}},
+			}
+		}
+		return &ast.CallExpr{
+			Fun:  &ast.SelectorExpr{X: curr, Sel: ast.NewIdent("Msg")},
+			Args: []ast.Expr{msg},
+		}
+	}
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: event, Sel: ast.NewIdent("Msg")},
+		Args: []ast.Expr{&ast.CallExpr{Fun: selector("fmt", "Sprint"), Args: args, Ellipsis: call.Ellipsis}},
+	}
+}
+
+// createReplaceGlobals rewrites the statement zap.ReplaceGlobals(l) to
+// log.Logger = l.
+func createReplaceGlobals(call *ast.CallExpr) ast.Stmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{selector("log", "Logger")},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{call.Args[0]},
+	}
+}
+
+// zerologLoggerMethods are the zerolog functions and methods that return a
+// zerolog.Logger value.
+var zerologLoggerMethods = map[string]bool{
+	"New": true, "Nop": true, "Logger": true, "Level": true, "Output": true, "Hook": true, "Sample": true,
+}
+
+// isZerologLogger reports whether e, the argument of zap.ReplaceGlobals,
+// is a zerolog.Logger value once rewritten to rewritten: by its type with
+// -types, or because it is a zerolog chain such as a rule produces.
+func (rw *rewriter) isZerologLogger(e, rewritten ast.Expr) bool {
+	if e == rewritten {
+		if t := rw.exprTypes.typeOf(rw.fset, e); t != nil {
+			return isNamed(t, zerologImport.path, "Logger")
+		}
+	}
+	call, ok := rewritten.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !zerologLoggerMethods[sel.Sel.Name] {
+		return false
+	}
+	root := sel.X
+	for {
+		switch x := root.(type) {
+		case *ast.CallExpr:
+			root = x.Fun
+			continue
+		case *ast.SelectorExpr:
+			root = x.X
+			continue
+		}
+		break
+	}
+	id, ok := root.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	if !id.NamePos.IsValid() {
+		return id.Name == zerologImport.name
+	}
+	spec := findImport(rw.file, zerologImport.path)
+	return spec != nil && importName(spec) == id.Name
+}
+
+// rewriteReplaceGlobals rewrites the statement zap.ReplaceGlobals(l) to
+// log.Logger = l if l is a zerolog logger. Otherwise the call is left and
+// reported: l is still a zap logger that log.Logger cannot hold.
+func (rw *rewriter) rewriteReplaceGlobals(x *ast.ExprStmt) ast.Stmt {
+	call := x.X.(*ast.CallExpr)
+	arg := call.Args[0]
+	call.Args[0] = rw.rewriteExpr(arg)
+	if !rw.isZerologLogger(arg, call.Args[0]) {
+		rw.report.replaceGlobals = append(rw.report.replaceGlobals, site{
+			pos:  rw.fset.Position(x.Pos()),
+			call: fmt.Sprintf("%s left as is: %s is not known to be a zerolog.Logger", nodeString(rw.fset, call.Fun), nodeString(rw.fset, call.Args[0])),
+		})
+		return x
+	}
+	stmt := createReplaceGlobals(call)
+	if rw.accept(x, stmt) {
+		rw.rewritten++
+		return stmt
+	}
+	return x
+}
+
+// recordGlobalInstalls records every place in f that installs a global
+// logger, either through zap.ReplaceGlobals or by assigning log.Logger.
+func (rw *rewriter) recordGlobalInstalls(f *ast.File, path string) {
+	pkg := filepath.Dir(path) + " (" + f.Name.Name + ")"
+	ast.Inspect(f, func(n ast.Node) bool {
+		var how string
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			if !isReplaceGlobals(x) {
+				return true
+			}
+			how = "zap.ReplaceGlobals"
+		case *ast.AssignStmt:
+			if len(x.Lhs) != 1 {
+				return true
+			}
+			sel, ok := x.Lhs[0].(*ast.SelectorExpr)
+			if !ok || !isIdent(sel.X, "log") || sel.Sel.Name != "Logger" {
+				return true
+			}
+			how = "log.Logger assignment"
+		default:
+			return true
+		}
+		if rw.report.globalInstalls == nil {
+			rw.report.globalInstalls = make(map[string][]site)
+		}
+		rw.report.globalInstalls[pkg] = append(rw.report.globalInstalls[pkg], site{
+			pos:  rw.fset.Position(n.Pos()),
+			call: how,
+		})
+		return true
+	})
+}
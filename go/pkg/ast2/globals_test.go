@@ -0,0 +1,72 @@
+package ast2
+
+import "testing"
+
+func TestGlobals(t *testing.T) {
+	const header = `package p
+
+import "go.uber.org/zap"
+
+`
+	runMigrateCases(t, []migrateCase{{
+		name: "zap.L",
+		src: header + `func f() {
+	zap.L().Info("hi", zap.String("a", "b"))
+}
+`,
+		want:    []string{`log.Info().Str("a", "b").Msg("hi")`, `"github.com/rs/zerolog/log"`},
+		notWant: []string{"go.uber.org/zap"},
+	}, {
+		name: "zap.S",
+		src: header + `func f(n int, args []interface{}) {
+	zap.S().Infof("n=%d", n)
+	zap.S().Warnw("hi", "n", n)
+	zap.S().Errorw("hi", args...)
+	zap.S().Debug("a", n)
+	zap.S().Info(args...)
+}
+`,
+		want: []string{
+			`log.Info().Msgf("n=%d", n)`,
+			`log.Warn().Fields([]interface{}{"n", n}).Msg("hi")`,
+			`log.Error().Fields(args).Msg("hi")`,
+			`log.Debug().Msg(fmt.Sprint("a", n))`,
+			`log.Info().Msg(fmt.Sprint(args...))`,
+		},
+		notWant: []string{"zap."},
+	}, {
+		// l is still a zap logger, which log.Logger cannot hold.
+		name: "ReplaceGlobals of a zap logger",
+		src: header + `func f(l *zap.Logger) {
+	zap.ReplaceGlobals(l)
+}
+`,
+		want:  []string{"zap.ReplaceGlobals(l)"},
+		diags: []string{CategoryGlobalInstall},
+	}, {
+		name: "ReplaceGlobals of a rewritten logger",
+		src: header + `func f() {
+	zap.ReplaceGlobals(newLogger())
+}
+
+func newLogger() *zap.Logger { return nil }
+`,
+		opts:    Options{Rules: []byte("import \"github.com/rs/zerolog\"\nimport \"os\"\nnewLogger() -> zerolog.New(os.Stderr)\n")},
+		want:    []string{"log.Logger = zerolog.New(os.Stderr)"},
+		notWant: []string{"ReplaceGlobals"},
+	}, {
+		name: "repeated installs",
+		src: header + `import (
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func f(w zerolog.LevelWriter) {
+	zap.ReplaceGlobals(zap.NewNop())
+	log.Logger = zerolog.New(w)
+}
+`,
+		want:  []string{"zap.ReplaceGlobals(zap.NewNop())"},
+		diags: []string{CategoryGlobalInstall, CategoryGlobalInstall, CategoryGlobalInstall},
+	}})
+}
@@ -0,0 +1,299 @@
+package ast2
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+const zapModule = "go.uber.org/zap"
+
+// findModuleRoot returns the directory of the go.mod governing path.
+func findModuleRoot(path string) (string, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod found above %s", path)
+		}
+		dir = parent
+	}
+}
+
+// updateGoMod brings the go.mod in root in line with the imports of the
+// module's files after a migration. Every module in pins that is imported
+// somewhere is required at its pinned version, and zap is dropped once
+// nothing imports it any more. Versions are resolved from the local
+// module cache only; nothing is downloaded. A vendored module is left
+// alone, with an error naming the go get that updates it, as its vendor
+// directory would no longer match go.mod.
+func updateGoMod(root string, pins map[string]string, j *journal) error {
+	gomodPath := filepath.Join(root, "go.mod")
+	data, err := os.ReadFile(gomodPath)
+	if err != nil {
+		return fmt.Errorf("reading go.mod: %w", err)
+	}
+	mf, err := modfile.Parse(gomodPath, data, nil)
+	if err != nil {
+		return fmt.Errorf("parsing go.mod: %w", err)
+	}
+
+	imports, err := moduleImports(root)
+	if err != nil {
+		return fmt.Errorf("scanning imports: %w", err)
+	}
+
+	paths := make([]string, 0, len(pins))
+	for path := range pins {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	if _, err := os.Stat(filepath.Join(root, "vendor", "modules.txt")); err == nil {
+		if gets := requireChanges(mf, imports, paths, pins); len(gets) > 0 {
+			return fmt.Errorf("the module is vendored; run go get %s and go mod vendor", strings.Join(gets, " "))
+		}
+		return nil
+	}
+
+	src := newModuleSource()
+	var sums []string
+	for _, path := range paths {
+		version := pins[path]
+		if !importsModule(imports, path) {
+			continue
+		}
+		if !src.has(path, version) {
+			return fmt.Errorf("%s@%s not found in the module cache", path, version)
+		}
+		if err := mf.AddRequire(path, version); err != nil {
+			return fmt.Errorf("adding %s: %w", path, err)
+		}
+		sums = append(sums, src.sums(path, version)...)
+
+		// Record the new module's own requirements as indirect so the
+		// build graph is complete without a network round trip.
+		deps, err := src.requirements(path, version)
+		if err != nil {
+			return fmt.Errorf("reading requirements of %s: %w", path, err)
+		}
+		for _, dep := range deps {
+			if _, pinned := pins[dep.Path]; pinned || isRequired(mf, dep) {
+				continue
+			}
+			mf.AddNewRequire(dep.Path, dep.Version, true)
+			sums = append(sums, src.sums(dep.Path, dep.Version)...)
+		}
+	}
+
+	if !importsModule(imports, zapModule) {
+		if err := mf.DropRequire(zapModule); err != nil {
+			return fmt.Errorf("dropping %s: %w", zapModule, err)
+		}
+	}
+
+	mf.Cleanup()
+	out, err := mf.Format()
+	if err != nil {
+		return fmt.Errorf("formatting go.mod: %w", err)
+	}
+	if err := j.write(gomodPath, out); err != nil {
+		return fmt.Errorf("writing go.mod: %w", err)
+	}
+	return addGoSum(filepath.Join(root, "go.sum"), sums, j)
+}
+
+// requireChanges returns the go get arguments that bring mf in line with
+// imports: the pinned modules imported at a version mf does not require,
+// and zap@none once nothing imports it.
+func requireChanges(mf *modfile.File, imports map[string]bool, paths []string, pins map[string]string) []string {
+	required := make(map[string]string)
+	for _, r := range mf.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	var gets []string
+	for _, path := range paths {
+		if importsModule(imports, path) && required[path] != pins[path] {
+			gets = append(gets, path+"@"+pins[path])
+		}
+	}
+	if _, ok := required[zapModule]; ok && !importsModule(imports, zapModule) {
+		gets = append(gets, zapModule+"@none")
+	}
+	return gets
+}
+
+// isRequired reports whether mf already requires dep at or above its version.
+func isRequired(mf *modfile.File, dep module.Version) bool {
+	for _, r := range mf.Require {
+		if r.Mod.Path == dep.Path && semver.Compare(r.Mod.Version, dep.Version) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func importsModule(imports map[string]bool, mod string) bool {
+	for imp := range imports {
+		if imp == mod || strings.HasPrefix(imp, mod+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleImports returns the import paths used by the Go files of the module
+// rooted at root, skipping vendor, testdata and nested modules.
+func moduleImports(root string) (map[string]bool, error) {
+	imports := make(map[string]bool)
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			name := d.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".go" {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		for _, imp := range f.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports[p] = true
+			}
+		}
+		return nil
+	})
+	return imports, err
+}
+
+// moduleSource looks modules up in the module cache.
+type moduleSource struct {
+	cacheDir string // $GOMODCACHE/cache/download
+}
+
+func newModuleSource() *moduleSource {
+	modCache := os.Getenv("GOMODCACHE")
+	if modCache == "" {
+		modCache = filepath.Join(build.Default.GOPATH, "pkg", "mod")
+	}
+	return &moduleSource{cacheDir: filepath.Join(modCache, "cache", "download")}
+}
+
+// cacheFile returns the module cache path of path@version with the given
+// extension (".mod", ".zip", ".ziphash").
+func (s *moduleSource) cacheFile(path, version, ext string) string {
+	epath, err := module.EscapePath(path)
+	if err != nil {
+		return ""
+	}
+	ever, err := module.EscapeVersion(version)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(s.cacheDir, epath, "@v", ever+ext)
+}
+
+func (s *moduleSource) has(path, version string) bool {
+	_, err := os.Stat(s.cacheFile(path, version, ".zip"))
+	return err == nil
+}
+
+// requirements returns the requirements listed in the go.mod of path@version.
+// Modules without a cached go.mod report none.
+func (s *moduleSource) requirements(path, version string) ([]module.Version, error) {
+	name := s.cacheFile(path, version, ".mod")
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	mf, err := modfile.ParseLax(name, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	var deps []module.Version
+	for _, r := range mf.Require {
+		deps = append(deps, r.Mod)
+	}
+	return deps, nil
+}
+
+// sums returns the go.sum lines for path@version that can be computed from
+// the module cache.
+func (s *moduleSource) sums(path, version string) []string {
+	var lines []string
+	if h, err := os.ReadFile(s.cacheFile(path, version, ".ziphash")); err == nil {
+		lines = append(lines, fmt.Sprintf("%s %s %s", path, version, strings.TrimSpace(string(h))))
+	}
+	modPath := s.cacheFile(path, version, ".mod")
+	if _, err := os.Stat(modPath); err == nil {
+		h, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return os.Open(modPath)
+		})
+		if err == nil {
+			lines = append(lines, fmt.Sprintf("%s %s/go.mod %s", path, version, h))
+		}
+	}
+	return lines
+}
+
+// addGoSum merges lines into the go.sum file at path, keeping it sorted.
+func addGoSum(path string, lines []string, j *journal) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading go.sum: %w", err)
+	}
+	have := make(map[string]bool)
+	var all []string
+	for _, l := range append(strings.Split(string(data), "\n"), lines...) {
+		if l != "" && !have[l] {
+			have[l] = true
+			all = append(all, l)
+		}
+	}
+	sort.Strings(all)
+	out := strings.Join(all, "\n") + "\n"
+	if err := j.write(path, []byte(out)); err != nil {
+		return fmt.Errorf("writing go.sum: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateGoMod(t *testing.T) {
+	const gomod = `module m
+
+go 1.21
+
+require go.uber.org/zap v1.27.0
+`
+	pins := map[string]string{
+		"github.com/rs/zerolog": "v1.34.0",
+		"github.com/pkg/errors": "v0.9.1",
+	}
+	tests := []struct {
+		name     string
+		src      string
+		vendored bool
+		want     []string
+		notWant  []string
+		err      string
+	}{{
+		name:    "zap dropped",
+		src:     `import "github.com/rs/zerolog/log"`,
+		want:    []string{"github.com/rs/zerolog v1.34.0"},
+		notWant: []string{"go.uber.org/zap", "github.com/pkg/errors"},
+	}, {
+		name: "zap kept",
+		src: `import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"go.uber.org/zap/zapcore"
+)`,
+		want: []string{"github.com/rs/zerolog v1.34.0", "github.com/pkg/errors v0.9.1", "go.uber.org/zap v1.27.0"},
+	}, {
+		name:    "nothing migrated",
+		src:     `import "go.uber.org/zap"`,
+		want:    []string{"go.uber.org/zap v1.27.0"},
+		notWant: []string{"zerolog", "errors"},
+	}, {
+		name:     "vendored",
+		src:      `import "github.com/rs/zerolog/log"`,
+		vendored: true,
+		want:     []string{"go.uber.org/zap v1.27.0"},
+		notWant:  []string{"zerolog"},
+		err:      "the module is vendored; run go get github.com/rs/zerolog@v1.34.0 go.uber.org/zap@none and go mod vendor",
+	}, {
+		name:     "vendored, nothing to change",
+		src:      `import "go.uber.org/zap"`,
+		vendored: true,
+		want:     []string{"go.uber.org/zap v1.27.0"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The pinned modules are in a module cache of their own.
+			modCache := t.TempDir()
+			t.Setenv("GOMODCACHE", modCache)
+			for path, version := range pins {
+				dir := filepath.Join("cache", "download", filepath.FromSlash(path), "@v")
+				writeFiles(t, modCache, map[string]string{
+					filepath.Join(dir, version+".zip"):     "",
+					filepath.Join(dir, version+".ziphash"): "h1:x=\n",
+					filepath.Join(dir, version+".mod"):     "module " + path + "\n",
+				})
+			}
+			root := t.TempDir()
+			files := map[string]string{
+				"go.mod":            gomod,
+				"p.go":              "package p\n\n" + tt.src + "\n",
+				"testdata/t.go":     "package t\n\nimport \"github.com/pkg/errors\"\n",
+				"sub/go.mod":        "module m/sub\n",
+				"sub/p.go":          "package sub\n\nimport \"github.com/pkg/errors\"\n",
+				"vendor/other/o.go": "package other\n\nimport \"github.com/pkg/errors\"\n",
+			}
+			if tt.vendored {
+				files["vendor/modules.txt"] = "# go.uber.org/zap v1.27.0\n## explicit\n"
+			}
+			writeFiles(t, root, files)
+			err := updateGoMod(root, pins, nil)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("updateGoMod: %v, want error %q", err, tt.err)
+			}
+			out, err := os.ReadFile(filepath.Join(root, "go.mod"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("go.mod does not require %s:\n%s", w, out)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(string(out), w) {
+					t.Errorf("go.mod requires %s:\n%s", w, out)
+				}
+			}
+		})
+	}
+}
+
+// writeFiles writes files, by slash-separated name under root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
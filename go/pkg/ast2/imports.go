@@ -0,0 +1,220 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// pkgImport is a package that generated code refers to. The rewrites use
+// name; when a file already binds name to something else, the package is
+// imported as alias and the generated references are renamed.
+type pkgImport struct {
+	path, name, alias string
+}
+
+var (
+	pkgErrorsImport  = pkgImport{path: "github.com/pkg/errors", name: "errors", alias: "pkgerrors"}
+	zerologImport    = pkgImport{path: "github.com/rs/zerolog", name: "zerolog", alias: "zl"}
+	zerologLogImport = pkgImport{path: "github.com/rs/zerolog/log", name: "log", alias: "zlog"}
+	fmtImport        = pkgImport{path: "fmt", name: "fmt", alias: "stdfmt"}
+)
+
+// generatedImports are the packages the built-in rewrites refer to.
+var generatedImports = []pkgImport{pkgErrorsImport, zerologImport, zerologLogImport, fmtImport}
+
+// fixImports imports the packages that generated code in f refers to and
+// renames the references when a package is imported under another name.
+// Generated references are told apart from the file's own by their
+// missing position.
+func (rw *rewriter) fixImports(f *ast.File) {
+	imps := generatedImports
+	if rw.cfg.rules != nil {
+		imps = append(imps[:len(imps):len(imps)], rw.cfg.rules.imports...)
+	}
+	for _, imp := range imps {
+		refs := generatedRefs(f, imp.name)
+		if len(refs) == 0 {
+			continue
+		}
+		name := ensureImport(rw.fset, f, imp)
+		switch name {
+		case imp.name:
+		case ".":
+			dequalify(f, refs)
+		default:
+			for id := range refs {
+				id.Name = name
+			}
+		}
+	}
+}
+
+// ensureImport makes f import imp and returns the name f refers to it by.
+func ensureImport(fset *token.FileSet, f *ast.File, imp pkgImport) string {
+	name, present := nameFor(f, imp)
+	if !present {
+		alias := ""
+		if name != assumedName(imp.path) {
+			alias = name
+		}
+		astutil.AddNamedImport(fset, f, alias, imp.path)
+	}
+	return name
+}
+
+// nameFor returns the name f refers to imp by, and whether f imports it
+// already. For a new import this is the first of imp.name, imp.alias,
+// imp.name2, ... that the file does not bind yet.
+func nameFor(f *ast.File, imp pkgImport) (string, bool) {
+	if spec := findImport(f, imp.path); spec != nil {
+		return importName(spec), true
+	}
+	if !nameTaken(f, imp.name) {
+		return imp.name, false
+	}
+	if imp.alias != "" && !nameTaken(f, imp.alias) {
+		return imp.alias, false
+	}
+	for i := 2; ; i++ {
+		if name := fmt.Sprintf("%s%d", imp.name, i); !nameTaken(f, name) {
+			return name, false
+		}
+	}
+}
+
+// findImport returns the import of path in f, ignoring blank imports.
+func findImport(f *ast.File, path string) *ast.ImportSpec {
+	for _, spec := range f.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err == nil && p == path && importName(spec) != "_" {
+			return spec
+		}
+	}
+	return nil
+}
+
+func isImportPresent(f *ast.File, path string) bool {
+	return findImport(f, path) != nil
+}
+
+// importName returns the name spec binds in the file.
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	path, _ := strconv.Unquote(spec.Path.Value)
+	return assumedName(path)
+}
+
+// assumedName guesses the package name of an import path the way
+// goimports does: the last element, skipping a major version suffix,
+// without a go- prefix and anything after a dot or dash.
+func assumedName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.IndexAny(name, ".-"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// nameTaken reports whether f binds name, through an import or any
+// declaration.
+func nameTaken(f *ast.File, name string) bool {
+	for _, spec := range f.Imports {
+		if importName(spec) == name {
+			return true
+		}
+	}
+	taken := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name && id.Obj != nil {
+			taken = true
+		}
+		return !taken
+	})
+	return taken
+}
+
+// generatedRefs returns the generated package qualifiers named name in n.
+func generatedRefs(n ast.Node, name string) map[*ast.Ident]bool {
+	refs := make(map[*ast.Ident]bool)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == name && !id.NamePos.IsValid() && id.Obj == nil {
+				refs[id] = true
+			}
+		}
+		return true
+	})
+	return refs
+}
+
+// dequalify drops the qualifiers in refs from n, for a package that is
+// dot imported.
+func dequalify(n ast.Node, refs map[*ast.Ident]bool) {
+	astutil.Apply(n, nil, func(c *astutil.Cursor) bool {
+		if sel, ok := c.Node().(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && refs[id] {
+				c.Replace(sel.Sel)
+			}
+		}
+		return true
+	})
+}
+
+// usesImport reports whether f refers to the package imported from path.
+// Dot imports are assumed to be used.
+func usesImport(f *ast.File, path string) bool {
+	spec := findImport(f, path)
+	if spec == nil {
+		return false
+	}
+	name := importName(spec)
+	return name == "." || usesPackage(f, name)
+}
+
+// usedImports returns the import paths of f that it refers to.
+func usedImports(f *ast.File) []string {
+	var paths []string
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil && usesImport(f, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// removeUnusedImports removes the imports of paths, as returned by
+// usedImports before f was rewritten, that f no longer refers to.
+func removeUnusedImports(fset *token.FileSet, f *ast.File, paths []string) {
+	for _, path := range paths {
+		if !usesImport(f, path) {
+			removeImport(fset, f, path)
+		}
+	}
+}
+
+// removeImport removes the imports of path from f, keeping blank imports.
+func removeImport(fset *token.FileSet, f *ast.File, path string) {
+	for _, spec := range append([]*ast.ImportSpec(nil), f.Imports...) {
+		if p, err := strconv.Unquote(spec.Path.Value); err != nil || p != path {
+			continue
+		}
+		name := ""
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name != "_" {
+			astutil.DeleteNamedImport(fset, f, name, path)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ast2
+
+import "testing"
+
+func TestAssumedName(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"github.com/rs/zerolog", "zerolog"},
+		{"github.com/go-chi/chi/v5", "chi"},
+		{"gopkg.in/yaml.v3", "yaml"},
+		{"github.com/mattn/go-isatty", "isatty"},
+		{"example.com/foo-bar", "foo"},
+		{"v2", "v2"},
+	}
+	for _, tt := range tests {
+		if got := assumedName(tt.path); got != tt.want {
+			t.Errorf("assumedName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "log taken by the standard library",
+		src: `package p
+
+import (
+	"log"
+
+	"go.uber.org/zap"
+)
+
+func f() {
+	log.Println("std")
+	zap.L().Info("hi")
+}
+`,
+		want:    []string{`zlog "github.com/rs/zerolog/log"`, `zlog.Info().Msg("hi")`, `log.Println("std")`},
+		notWant: []string{"go.uber.org/zap"},
+	}, {
+		name: "log taken by a declaration",
+		src: `package p
+
+import "go.uber.org/zap"
+
+var log = 1
+
+func f() {
+	zap.L().Info("hi")
+}
+`,
+		want: []string{`zlog "github.com/rs/zerolog/log"`, `zlog.Info().Msg("hi")`},
+	}, {
+		name: "zerolog log already imported under another name",
+		src: `package p
+
+import (
+	zl "github.com/rs/zerolog/log"
+	"go.uber.org/zap"
+)
+
+func f() {
+	zl.Debug().Msg("x")
+	zap.L().Info("hi")
+}
+`,
+		want: []string{`zl.Info().Msg("hi")`},
+	}, {
+		name: "zerolog log dot imported",
+		src: `package p
+
+import (
+	. "github.com/rs/zerolog/log"
+	"go.uber.org/zap"
+)
+
+func f() {
+	Debug().Msg("x")
+	zap.L().Info("hi")
+}
+`,
+		want:    []string{`Info().Msg("hi")`},
+		notWant: []string{"log.Info"},
+	}, {
+		name: "zap kept while still used",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f() *zap.Logger {
+	zap.L().Info("hi")
+	return zap.L()
+}
+`,
+		want: []string{`"go.uber.org/zap"`, `"github.com/rs/zerolog/log"`},
+	}})
+}
@@ -0,0 +1,186 @@
+package ast2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"strings"
+)
+
+// reviewer asks for approval of each rewrite in -interactive mode. Answers
+// are saved to a state file after every prompt so that an interrupted
+// session resumes where it stopped.
+type reviewer struct {
+	in        *bufio.Reader
+	out       io.Writer
+	statePath string
+	state     map[string]*fileAnswers // by file name
+	seen      map[string]int          // occurrences of a hunk text in the current run
+	all       bool                    // accept everything that follows
+	quit      bool                    // reject everything that follows
+	accepted  int
+	total     int
+}
+
+// fileAnswers are the answers given for the hunks of one file. They only
+// apply to the source they were given for: once the file is written with
+// the accepted hunks, the hunks left no longer line up with the answers.
+type fileAnswers struct {
+	Hash    string          `json:"hash"`
+	Answers map[string]bool `json:"answers"` // by hunk key
+}
+
+func newReviewer(in io.Reader, out io.Writer, statePath string) (*reviewer, error) {
+	rv := &reviewer{
+		in:        bufio.NewReader(in),
+		out:       out,
+		statePath: statePath,
+		state:     make(map[string]*fileAnswers),
+		seen:      make(map[string]int),
+	}
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return rv, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading review state: %w", err)
+	}
+	if err := json.Unmarshal(data, &rv.state); err != nil {
+		return nil, fmt.Errorf("parsing review state %s: %w", statePath, err)
+	}
+	n := 0
+	for _, fa := range rv.state {
+		n += len(fa.Answers)
+	}
+	fmt.Fprintf(out, "Resuming review with %d recorded answer(s) from %s\n", n, statePath)
+	return rv, nil
+}
+
+// begin starts the review of the file at path with content src. Answers
+// recorded for another content of the file are dropped.
+func (rv *reviewer) begin(path string, src []byte) {
+	sum := sha256.Sum256(src)
+	hash := hex.EncodeToString(sum[:])
+	if fa := rv.state[path]; fa != nil && fa.Hash == hash {
+		return
+	}
+	rv.state[path] = &fileAnswers{Hash: hash, Answers: make(map[string]bool)}
+}
+
+// accept reports whether the rewrite of orig into repl should be applied.
+func (rw *rewriter) accept(orig, repl ast.Node) bool {
+	if rw.cfg.review == nil {
+		return rw.acceptText(orig, "")
+	}
+	return rw.acceptText(orig, nodeString(rw.fset, repl))
+}
+
+// acceptText is accept for a replacement given as source text.
+func (rw *rewriter) acceptText(orig ast.Node, after string) bool {
+	if rw.lines != nil && !rw.touchesChangedLine(orig) {
+		return false
+	}
+	rv := rw.cfg.review
+	if rv == nil {
+		return true
+	}
+	before := nodeString(rw.fset, orig)
+	pos := rw.fset.Position(orig.Pos())
+
+	// Positions shift as hunks are applied, so a hunk is identified by its
+	// text and the number of identical hunks before it in the file.
+	id := pos.Filename + "\n" + before
+	rv.seen[id]++
+	key := fmt.Sprintf("#%d: %s", rv.seen[id], before)
+
+	ok := rv.ask(rw.path, key, pos, before, after)
+	rv.total++
+	if ok {
+		rv.accepted++
+	}
+	return ok
+}
+
+func (rv *reviewer) ask(path, key string, pos token.Position, before, after string) bool {
+	fa := rv.state[path]
+	if fa == nil {
+		// Rewrites made outside processSource, which began the others.
+		fa = &fileAnswers{Answers: make(map[string]bool)}
+		rv.state[path] = fa
+	}
+	if ok, answered := fa.Answers[key]; answered {
+		return ok
+	}
+	if rv.all || rv.quit {
+		return rv.all
+	}
+
+	fmt.Fprintf(rv.out, "\n%s\n%s%s", pos, prefixLines("- ", before), prefixLines("+ ", after))
+	for {
+		fmt.Fprint(rv.out, "Apply this rewrite? [y,n,a,q] ")
+		line, err := rv.in.ReadString('\n')
+		answer := strings.TrimSpace(line)
+		if err != nil && answer == "" {
+			answer = "q"
+		}
+		switch answer {
+		case "y", "n":
+			rv.record(fa, key, answer == "y")
+			return answer == "y"
+		case "a":
+			rv.all = true
+			rv.record(fa, key, true)
+			return true
+		case "q":
+			rv.quit = true
+			return false
+		}
+		fmt.Fprintln(rv.out, "y - apply, n - skip, a - apply this and all remaining, q - quit")
+	}
+}
+
+func (rv *reviewer) record(fa *fileAnswers, key string, ok bool) {
+	fa.Answers[key] = ok
+	data, err := json.MarshalIndent(rv.state, "", "  ")
+	if err == nil {
+		err = os.WriteFile(rv.statePath, data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(rv.out, "Error saving review state: %v\n", err)
+	}
+}
+
+// finish prints a summary and drops the state file once every hunk has
+// been answered.
+func (rv *reviewer) finish() {
+	fmt.Fprintf(rv.out, "Accepted %d of %d rewrite(s)\n", rv.accepted, rv.total)
+	if rv.quit {
+		fmt.Fprintf(rv.out, "Review stopped; answers are kept in %s\n", rv.statePath)
+		return
+	}
+	os.Remove(rv.statePath)
+}
+
+func nodeString(fset *token.FileSet, n ast.Node) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, n); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return buf.String()
+}
+
+func prefixLines(prefix, s string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		b.WriteString(prefix + line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package ast2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInteractive(t *testing.T) {
+	const src = `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	zap.L().Info("one")
+	zap.L().Info("two")
+	zap.L().Info("three")
+}
+`
+	tests := []struct {
+		name    string
+		answers string
+		state   map[string]bool // recorded answers to resume from
+		stale   bool            // the answers were given for another source
+		want    []string
+		kept    bool // the state file remains
+	}{{
+		name:    "yes and no",
+		answers: "y\nn\ny\n",
+		want:    []string{`log.Info().Msg("one")`, `zap.L().Info("two")`, `log.Info().Msg("three")`},
+	}, {
+		name:    "help then all",
+		answers: "?\na\n",
+		want:    []string{`log.Info().Msg("one")`, `log.Info().Msg("two")`, `log.Info().Msg("three")`},
+	}, {
+		name:    "quit",
+		answers: "y\nq\n",
+		want:    []string{`log.Info().Msg("one")`, `zap.L().Info("two")`, `zap.L().Info("three")`},
+		kept:    true,
+	}, {
+		name:    "end of input quits",
+		answers: "n\n",
+		want:    []string{`zap.L().Info("one")`, `zap.L().Info("two")`},
+		kept:    true,
+	}, {
+		name:    "resumed",
+		state:   map[string]bool{`#1: zap.L().Info("one")`: false, `#1: zap.L().Info("two")`: true},
+		answers: "y\n",
+		want:    []string{`zap.L().Info("one")`, `log.Info().Msg("two")`, `log.Info().Msg("three")`},
+	}, {
+		// The file was written since, so the answers no longer apply.
+		name:    "stale answers",
+		state:   map[string]bool{`#1: zap.L().Info("one")`: false},
+		stale:   true,
+		answers: "y\ny\ny\n",
+		want:    []string{`log.Info().Msg("one")`, `log.Info().Msg("two")`, `log.Info().Msg("three")`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statePath := filepath.Join(t.TempDir(), "state.json")
+			if tt.state != nil {
+				content := src
+				if tt.stale {
+					content += "// edited\n"
+				}
+				sum := sha256.Sum256([]byte(content))
+				state, err := json.Marshal(map[string]*fileAnswers{"testdata/p.go": {Hash: hex.EncodeToString(sum[:]), Answers: tt.state}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(statePath, state, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := defaultConfig()
+			rv, err := newReviewer(strings.NewReader(tt.answers), io.Discard, statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.review = rv
+			out, _, err := processSource("testdata/p.go", []byte(src), cfg, &report{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rv.finish()
+			if out == nil {
+				out = []byte(src)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("output does not contain %s:\n%s", w, out)
+				}
+			}
+			if _, err := os.Stat(statePath); (err == nil) != tt.kept {
+				t.Errorf("state file kept: %t, want %t", err == nil, tt.kept)
+			}
+		})
+	}
+}
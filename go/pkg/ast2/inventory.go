@@ -0,0 +1,444 @@
+package ast2
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Migration classes of the constructs found by the inventory.
+const (
+	classAuto    = "auto"
+	classPartial = "partial"
+	classManual  = "manual"
+)
+
+// inventory is a histogram of the zap API used by each package, with
+// every construct classified by how much of it the migrator handles.
+type inventory struct {
+	Packages   map[string]*packageInventory `json:"packages"`
+	Automation float64                      `json:"automationPercent"`
+
+	types     *typeLoader                // with -types, resolves loggers by type
+	fields    map[string]map[string]bool // struct fields of zap logger type, by directory
+	templates []*fieldTemplate           // the fieldTemplates of -config
+}
+
+type packageInventory struct {
+	Constructs map[string]*construct `json:"constructs"`
+	Automation float64               `json:"automationPercent"`
+}
+
+// construct counts the uses of one API by migration class.
+type construct struct {
+	Kind    string `json:"kind"`
+	Auto    int    `json:"auto"`
+	Partial int    `json:"partial"`
+	Manual  int    `json:"manual"`
+}
+
+func (c *construct) count() int {
+	return c.Auto + c.Partial + c.Manual
+}
+
+// zapLoggerMethods are methods of *zap.Logger and *zap.SugaredLogger that
+// the migrator leaves to a human, and Sync, which it removes.
+var zapLoggerMethods = map[string]string{
+	"With":        "with",
+	"WithOptions": "with",
+	"Named":       "named",
+	"Sugar":       "sugar",
+	"Desugar":     "sugar",
+	"Check":       "check",
+	"Core":        "core",
+	"Sync":        "lifecycle",
+}
+
+// zapConstructors build loggers or cores and need a hand-written zerolog
+// setup.
+var zapConstructors = map[string]bool{
+	"New":              true,
+	"NewProduction":    true,
+	"NewDevelopment":   true,
+	"NewExample":       true,
+	"NewNop":           true,
+	"WrapCore":         true,
+	"NewAtomicLevel":   true,
+	"NewAtomicLevelAt": true,
+}
+
+// runInventory builds the inventory of the file or directory, prints it as
+// a table and writes it as JSON to out. With typed set, zap loggers are
+// told by the type checker rather than by their declarations. Log calls
+// are classified with the field templates of cfg. Files that cannot be
+// read are reported on stderr.
+func runInventory(file, dir, out string, typed bool, cfg *config) error {
+	inv := newInventory()
+	inv.templates = cfg.FieldTemplates
+	if typed {
+		inv.types = newTypeLoader()
+	}
+	if file != "" {
+		if err := inv.inventoryFile(file); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	} else {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(path) == ".go" {
+				if err := inv.inventoryFile(path); err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing file %s: %v\n", path, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	inv.finish()
+	inv.writeText(os.Stdout)
+	return inv.writeJSON(out)
+}
+
+func newInventory() *inventory {
+	return &inventory{Packages: make(map[string]*packageInventory), fields: make(map[string]map[string]bool)}
+}
+
+func (inv *inventory) add(pkg, name, kind, class string) {
+	p, ok := inv.Packages[pkg]
+	if !ok {
+		p = &packageInventory{Constructs: make(map[string]*construct)}
+		inv.Packages[pkg] = p
+	}
+	c, ok := p.Constructs[name]
+	if !ok {
+		c = &construct{Kind: kind}
+		p.Constructs[name] = c
+	}
+	switch class {
+	case classAuto:
+		c.Auto++
+	case classPartial:
+		c.Partial++
+	default:
+		c.Manual++
+	}
+}
+
+// inventoryFile records the zap constructs used in the file at path.
+func (inv *inventory) inventoryFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("parsing file: %w", err)
+	}
+	pkg := filepath.Dir(path) + " (" + f.Name.Name + ")"
+	isLogger := inv.loggerResolver(path, src, fset)
+
+	for _, decl := range f.Decls {
+		inMethod := false
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			inMethod = fd.Recv != nil && len(fd.Recv.List) > 0 && len(fd.Recv.List[0].Names) > 0
+			if k := marshalerOf(fd); k != nil {
+				class := classAuto
+				if _, err := convertMarshaler(fset, src, fd, k, "zerolog"); err != nil {
+					class = classManual
+				}
+				inv.add(pkg, "zapcore."+strings.TrimPrefix(k.zeroIface, "Log"), "marshaler", class)
+				continue
+			}
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.CallExpr:
+				inv.inventoryCall(pkg, x, inMethod, isLogger)
+			case *ast.SelectorExpr:
+				if isIdent(x.X, "zapcore") && x.Sel.Name != "Field" {
+					inv.add(pkg, "zapcore."+x.Sel.Name, "core", classManual)
+				}
+			}
+			return true
+		})
+	}
+	return nil
+}
+
+// loggerResolver returns the function telling whether an expression of the
+// file at path is a zap logger.
+func (inv *inventory) loggerResolver(path string, src []byte, fset *token.FileSet) func(ast.Expr) bool {
+	var ft *fileTypes
+	if inv.types != nil {
+		rep := &report{}
+		ft = inv.types.fileTypes(path, src, rep)
+		for _, s := range rep.errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", s.pos, s.call)
+		}
+	}
+	fields := inv.loggerFields(filepath.Dir(path))
+	return func(e ast.Expr) bool {
+		if isZapLoggerExpr(e) {
+			return true
+		}
+		if t := ft.typeOf(fset, e); t != nil {
+			return isZapLoggerType(t)
+		}
+		return declaredZapLogger(e, fields)
+	}
+}
+
+// loggerFields returns the names of the struct fields of zap logger type
+// declared in the Go files of dir.
+func (inv *inventory) loggerFields(dir string) map[string]bool {
+	if fields, ok := inv.fields[dir]; ok {
+		return fields
+	}
+	fields := make(map[string]bool)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	fset := token.NewFileSet()
+	for _, m := range matches {
+		f, err := parser.ParseFile(fset, m, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			st, ok := n.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			for _, field := range st.Fields.List {
+				if !isZapLoggerTypeExpr(field.Type) {
+					continue
+				}
+				for _, name := range field.Names {
+					fields[name.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	inv.fields[dir] = fields
+	return fields
+}
+
+// declaredZapLogger reports whether e is a zap logger by its declaration:
+// a variable, parameter or result declared with a zap logger type or bound
+// to a zap logger, a call of a zap constructor or of With, Named or Sugar
+// on a zap logger, or a selector of one of fields.
+func declaredZapLogger(e ast.Expr, fields map[string]bool) bool {
+	switch x := e.(type) {
+	case *ast.ParenExpr:
+		return declaredZapLogger(x.X, fields)
+	case *ast.SelectorExpr:
+		return fields[x.Sel.Name]
+	case *ast.CallExpr:
+		sel, ok := x.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		if isIdent(sel.X, "zap") {
+			return loggerConstructors[sel.Sel.Name]
+		}
+		switch zapLoggerMethods[sel.Sel.Name] {
+		case "with", "named", "sugar":
+			return declaredZapLogger(sel.X, fields)
+		}
+	case *ast.Ident:
+		if x.Obj == nil {
+			return false
+		}
+		switch d := x.Obj.Decl.(type) {
+		case *ast.Field:
+			return isZapLoggerTypeExpr(d.Type)
+		case *ast.ValueSpec:
+			if d.Type != nil {
+				return isZapLoggerTypeExpr(d.Type)
+			}
+		}
+		if v := boundValue(x); v != nil {
+			return declaredZapLogger(v, fields)
+		}
+	}
+	return false
+}
+
+// isZapLoggerTypeExpr reports whether the type expression t is a zap
+// Logger or SugaredLogger, or a pointer to one.
+func isZapLoggerTypeExpr(t ast.Expr) bool {
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	return isZapLogger(t)
+}
+
+// isLevelMethod reports whether name is a level method of a zap Logger or
+// SugaredLogger, such as Info, Infof or Infow.
+func isLevelMethod(name string) bool {
+	if logLevels[name] {
+		return true
+	}
+	for _, suffix := range []string{"f", "w"} {
+		if level := strings.TrimSuffix(name, suffix); level != name && logLevels[level] {
+			return true
+		}
+	}
+	return false
+}
+
+func (inv *inventory) inventoryCall(pkg string, call *ast.CallExpr, inMethod bool, isLogger func(ast.Expr) bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	name := sel.Sel.Name
+
+	switch {
+	case isUtilsLogger(sel.X) && logLevels[name]:
+		class := classManual // only methods with a receiver are rewritten
+		if inMethod {
+			class = fieldsClass(call.Args, inv.templates)
+		}
+		inv.add(pkg, "level."+name, "level", class)
+		return
+	case isGlobalLogger(sel.X):
+		if level, _ := globalLevel(sel); level != "" {
+			class := classAuto
+			if isPkgCall(sel.X.(*ast.CallExpr), "zap", "L") {
+				class = fieldsClass(call.Args, inv.templates)
+			}
+			inv.add(pkg, types.ExprString(sel), "global", class)
+			return
+		}
+	case isReplaceGlobals(call):
+		// Rewritten only once the logger it installs is a zerolog one.
+		inv.add(pkg, "zap.ReplaceGlobals", "global", classPartial)
+		return
+	case isPkgCall(call, "zaptest", "NewLogger"), isPkgCall(call, "observer", "New"):
+		inv.add(pkg, types.ExprString(sel), "test", classAuto)
+		return
+	case isIdent(sel.X, "zap"):
+		if _, ok := zapToZero[name]; ok {
+			inv.add(pkg, "zap."+name, "field", classAuto)
+		} else if name == "NewAtomicLevel" || name == "NewAtomicLevelAt" {
+			// Rewritten when all uses of the level have a zerolog form.
+			inv.add(pkg, "zap."+name, "lifecycle", classPartial)
+		} else if zapConstructors[name] {
+			inv.add(pkg, "zap."+name, "constructor", classManual)
+		} else if name != "L" && name != "S" {
+			inv.add(pkg, "zap."+name, "field", classManual)
+		}
+		return
+	}
+
+	if kind, ok := zapLoggerMethods[name]; ok && isLogger(sel.X) {
+		class := classManual
+		if name == "Sync" {
+			class = classAuto
+		}
+		inv.add(pkg, "Logger."+name, kind, class)
+	} else if isLevelMethod(name) && isLogger(sel.X) {
+		// Only utils.Logger and the global loggers are rewritten.
+		inv.add(pkg, "Logger."+name, "level", classManual)
+	}
+}
+
+// fieldsClass classifies a log call by the fields it passes, the way the
+// migrator treats them: a call with a field it cannot rewrite, for lack
+// of a zerolog equivalent or a template, is left as is.
+func fieldsClass(args []ast.Expr, templates []*fieldTemplate) string {
+	if len(args) == 0 {
+		return classManual
+	}
+	for _, field := range args[1:] {
+		if fieldProblem(field, templates) != "" {
+			return classManual
+		}
+	}
+	return classAuto
+}
+
+// isZapLoggerExpr reports whether e is one of the zap loggers the migrator
+// recognizes, possibly behind With or Named calls.
+func isZapLoggerExpr(e ast.Expr) bool {
+	if isUtilsLogger(e) || isGlobalLogger(e) {
+		return true
+	}
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && zapLoggerMethods[sel.Sel.Name] != "" && isZapLoggerExpr(sel.X)
+}
+
+// finish computes the automation percentages: auto constructs count fully,
+// partial ones half.
+func (inv *inventory) finish() {
+	var total, done float64
+	for _, p := range inv.Packages {
+		var pt, pd float64
+		for _, c := range p.Constructs {
+			pt += float64(c.count())
+			pd += float64(c.Auto) + float64(c.Partial)/2
+		}
+		p.Automation = percent(pd, pt)
+		total += pt
+		done += pd
+	}
+	inv.Automation = percent(done, total)
+}
+
+func percent(part, total float64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(int(part/total*1000+0.5)) / 10
+}
+
+func (inv *inventory) writeJSON(path string) error {
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (inv *inventory) writeText(w io.Writer) {
+	pkgs := make([]string, 0, len(inv.Packages))
+	for pkg := range inv.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, pkg := range pkgs {
+		p := inv.Packages[pkg]
+		fmt.Fprintf(tw, "%s\tauto\tpartial\tmanual\t%.1f%% automatic\n", pkg, p.Automation)
+		names := make([]string, 0, len(p.Constructs))
+		for name := range p.Constructs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c := p.Constructs[name]
+			fmt.Fprintf(tw, "  %s (%s)\t%d\t%d\t%d\t\n", name, c.Kind, c.Auto, c.Partial, c.Manual)
+		}
+	}
+	fmt.Fprintf(tw, "total\t\t\t\t%.1f%% automatic\n", inv.Automation)
+	tw.Flush()
+}
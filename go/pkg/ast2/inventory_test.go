@@ -0,0 +1,150 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInventoryReceivers(t *testing.T) {
+	const header = `package p
+
+import "go.uber.org/zap"
+
+type S struct {
+	log   *zap.Logger
+	sugar *zap.SugaredLogger
+	other *Other
+}
+
+type Other struct{}
+
+func (*Other) Info(string) {}
+func (*Other) Sync() error { return nil }
+`
+	tests := []struct {
+		name  string
+		src   string
+		want  []string // constructs counted
+		notIn []string // constructs not counted
+	}{{
+		name: "struct field",
+		src: header + `
+func (s *S) A() { s.log.Info("hi"); s.sugar.Infow("hi"); s.log.Sync() }
+`,
+		want: []string{"Logger.Info", "Logger.Infow", "Logger.Sync"},
+	}, {
+		name: "param and local",
+		src: header + `
+func A(l *zap.Logger) {
+	l.Warn("hi")
+	var s *zap.SugaredLogger
+	s.Errorf("hi")
+}
+`,
+		want: []string{"Logger.Warn", "Logger.Errorf"},
+	}, {
+		name: "constructor result",
+		src: header + `
+func A() {
+	l, _ := zap.NewProduction()
+	l.Named("x").Debug("hi")
+}
+`,
+		want: []string{"Logger.Debug", "Logger.Named"},
+	}, {
+		name: "other receivers",
+		src: header + `
+func (s *S) A() { s.other.Info("hi"); s.other.Sync() }
+`,
+		notIn: []string{"Logger.Info", "Logger.Sync"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "p.go")
+			if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			inv := newInventory()
+			if err := inv.inventoryFile(path); err != nil {
+				t.Fatal(err)
+			}
+			counted := make(map[string]bool)
+			for _, p := range inv.Packages {
+				for name := range p.Constructs {
+					counted[name] = true
+				}
+			}
+			for _, name := range tt.want {
+				if !counted[name] {
+					t.Errorf("%s not counted, got %v", name, counted)
+				}
+			}
+			for _, name := range tt.notIn {
+				if counted[name] {
+					t.Errorf("%s counted", name)
+				}
+			}
+		})
+	}
+}
+
+func TestInventoryFieldClasses(t *testing.T) {
+	const src = `package p
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f(t Tenant, b []byte) {
+	zap.L().Info("known", zap.String("a", "b"))
+	zap.L().Info("template", logfields.Tenant(t))
+	zap.L().Info("unknown", zap.Binary("b", b))
+	zap.L().Info("no template", logfields.Other(t))
+}
+`
+	tests := []struct {
+		name         string
+		config       string
+		auto, manual int
+	}{{
+		name: "without templates",
+		auto: 1, manual: 3,
+	}, {
+		name:   "with templates",
+		config: tenantConfig,
+		auto:   2, manual: 2,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "p.go")
+			if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var data []byte
+			if tt.config != "" {
+				data = []byte(tt.config)
+			}
+			cfg, err := parseConfig("config", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inv := newInventory()
+			inv.templates = cfg.FieldTemplates
+			if err := inv.inventoryFile(path); err != nil {
+				t.Fatal(err)
+			}
+			var c construct
+			for _, p := range inv.Packages {
+				if got := p.Constructs["zap.L().Info"]; got != nil {
+					c = *got
+				}
+			}
+			if c.Auto != tt.auto || c.Manual != tt.manual || c.Partial != 0 {
+				t.Errorf("zap.L().Info counted %+v, want %d auto and %d manual", c, tt.auto, tt.manual)
+			}
+		})
+	}
+}
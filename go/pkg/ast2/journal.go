@@ -0,0 +1,150 @@
+package ast2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// journal records the original contents of every file a run writes, before
+// the file is replaced, so that -undo can restore the previous tree even if
+// the run stopped midway. It is a file of JSON lines, one entry per write.
+type journal struct {
+	f    *os.File
+	path string
+}
+
+// journalEntry is the state of a file before it was written. Files that
+// did not exist are removed on undo.
+type journalEntry struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	Content []byte      `json:"content,omitempty"`
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	return &journal{f: f, path: path}, nil
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// record appends the current state of path and syncs the journal.
+func (j *journal) record(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	e := journalEntry{Path: abs}
+	info, err := os.Stat(abs)
+	switch {
+	case err == nil:
+		e.Existed = true
+		e.Mode = info.Mode().Perm()
+		if e.Content, err = os.ReadFile(abs); err != nil {
+			return fmt.Errorf("journaling %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("journaling %s: %w", path, err)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+// write journals path and then replaces it with data. j may be nil, in
+// which case the file is only replaced.
+func (j *journal) write(path string, data []byte) error {
+	if j != nil {
+		if err := j.record(path); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory and a rename, keeping the permissions of an existing file.
+func writeFileAtomic(path string, data []byte) error {
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// undoJournal restores the files recorded in the journal at path, newest
+// entry first, so every file ends up as it was before the run.
+func undoJournal(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("opening journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	for sc.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// A crash can leave a torn last line; its file was not written yet.
+			break
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, fmt.Errorf("reading journal: %w", err)
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if !e.Existed {
+			if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+				return 0, fmt.Errorf("removing %s: %w", e.Path, err)
+			}
+			continue
+		}
+		if err := writeFileAtomic(e.Path, e.Content); err != nil {
+			return 0, fmt.Errorf("restoring %s: %w", e.Path, err)
+		}
+		if err := os.Chmod(e.Path, e.Mode); err != nil {
+			return 0, fmt.Errorf("restoring mode of %s: %w", e.Path, err)
+		}
+	}
+	return len(entries), nil
+}
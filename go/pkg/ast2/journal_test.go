@@ -0,0 +1,66 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalUndo(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string // file written in order, each with a new content
+		torn   bool     // the run stopped while journaling a further write
+	}{
+		{name: "one write", writes: []string{"a.go"}},
+		{name: "written twice", writes: []string{"a.go", "a.go"}},
+		{name: "created", writes: []string{"new.go", "a.go"}},
+		{name: "created and rewritten", writes: []string{"new.go", "new.go"}},
+		{name: "torn last entry", writes: []string{"a.go"}, torn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := filepath.Join(dir, "a.go")
+			if err := os.WriteFile(a, []byte("package a\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			jpath := filepath.Join(dir, "run.journal")
+			j, err := openJournal(jpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, name := range tt.writes {
+				if err := j.write(filepath.Join(dir, name), []byte{byte('0' + i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.torn {
+				if _, err := j.f.WriteString(`{"path":`); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := j.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := undoJournal(jpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.writes) {
+				t.Errorf("undo restored %d write(s), want %d", n, len(tt.writes))
+			}
+			data, err := os.ReadFile(a)
+			if err != nil || string(data) != "package a\n" {
+				t.Errorf("a.go = %q, %v, want it restored", data, err)
+			}
+			if info, err := os.Stat(a); err == nil && info.Mode().Perm() != 0o600 {
+				t.Errorf("a.go mode = %v, want 0600", info.Mode().Perm())
+			}
+			if _, err := os.Stat(filepath.Join(dir, "new.go")); !os.IsNotExist(err) {
+				t.Errorf("new.go not removed: %v", err)
+			}
+		})
+	}
+}
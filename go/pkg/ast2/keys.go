@@ -0,0 +1,212 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// keyedMethods are the zerolog event methods whose first argument is the
+// field key.
+var keyedMethods = map[string]bool{
+	"Str":       true,
+	"Int":       true,
+	"Int64":     true,
+	"Uint":      true,
+	"Uint64":    true,
+	"Bool":      true,
+	"Float64":   true,
+	"Dur":       true,
+	"Time":      true,
+	"Interface": true,
+	"AnErr":     true,
+	"Object":    true,
+	"Array":     true,
+	"Int8":      true,
+	"Int16":     true,
+	"Int32":     true,
+	"Uint8":     true,
+	"Uint16":    true,
+	"Uint32":    true,
+	"Float32":   true,
+	"Stringer":  true,
+	"RawJSON":   true,
+	"Strs":      true,
+	"Ints":      true,
+	"Ints64":    true,
+	"Uints":     true,
+	"Uints64":   true,
+	"Bools":     true,
+	"Floats32":  true,
+	"Floats64":  true,
+	"Durs":      true,
+	"Times":     true,
+	"Errs":      true,
+}
+
+// normalizeChain applies the configured key renames and case policy to the
+// fields of a zerolog chain built by zerologChain. Keys that are neither
+// string literals nor constants of the file, constants the policy would
+// rename, and keys that end up equal within the chain are reported.
+// With -types, zap.Any fields are first given the method of their type.
+func (rw *rewriter) normalizeChain(chain ast.Expr) {
+	if rw.exprTypes != nil {
+		specializeAny(chain, rw.typeOf, rw.generatesMarshaler)
+	}
+	seen := make(map[string]bool)
+	for {
+		call, ok := chain.(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return
+		}
+		switch {
+		case sel.Sel.Name == "Err" && len(call.Args) == 1:
+			// zap.Error logs under the implicit key "error".
+			key := rw.normalizeKey("error")
+			if key != rw.cfg.ErrorFieldName {
+				sel.Sel = ast.NewIdent("AnErr")
+				call.Args = []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(key)}, call.Args[0]}
+			}
+			rw.checkCollision(seen, key, call.Pos())
+		case keyedMethods[sel.Sel.Name] && len(call.Args) == 2:
+			if id, ok := call.Args[0].(*ast.Ident); ok {
+				if key, ok := constString(id); ok {
+					// The constant may be used elsewhere, so it is not
+					// renamed.
+					if renamed := rw.normalizeKey(key); renamed != key {
+						rw.keyIssue(id.Pos(), fmt.Sprintf("key %s is the constant %q and was left as is rather than renamed to %q", id.Name, key, renamed))
+					}
+					rw.checkCollision(seen, key, id.Pos())
+					break
+				}
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				rw.keyIssue(call.Args[0].Pos(), fmt.Sprintf("key %s is not a string literal or a constant of this file and was left as is", types.ExprString(call.Args[0])))
+				break
+			}
+			key, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				break
+			}
+			key = rw.normalizeKey(key)
+			call.Args[0] = &ast.BasicLit{ValuePos: lit.ValuePos, Kind: token.STRING, Value: strconv.Quote(key)}
+			rw.checkCollision(seen, key, lit.Pos())
+		}
+		chain = sel.X
+	}
+}
+
+// constString returns the value of a string literal, or of a constant
+// declared in the file with one, directly or through other constants.
+func constString(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.ParenExpr:
+		return constString(e.X)
+	case *ast.Ident:
+		if e.Obj == nil || e.Obj.Kind != ast.Con {
+			return "", false
+		}
+		spec, ok := e.Obj.Decl.(*ast.ValueSpec)
+		if !ok {
+			return "", false
+		}
+		for i, name := range spec.Names {
+			if name.Name == e.Name && i < len(spec.Values) {
+				return constString(spec.Values[i])
+			}
+		}
+	}
+	return "", false
+}
+
+func (rw *rewriter) checkCollision(seen map[string]bool, key string, pos token.Pos) {
+	if seen[key] {
+		rw.keyIssue(pos, fmt.Sprintf("key %q is used more than once in the same call", key))
+	}
+	seen[key] = true
+}
+
+func (rw *rewriter) keyIssue(pos token.Pos, msg string) {
+	rw.report.keyIssues = append(rw.report.keyIssues, site{pos: rw.fset.Position(pos), call: msg})
+}
+
+// normalizeKey returns the key a zap field key is renamed to.
+func (rw *rewriter) normalizeKey(key string) string {
+	if renamed, ok := rw.cfg.KeyRenames[key]; ok {
+		return renamed
+	}
+	switch rw.cfg.KeyCase {
+	case "snake":
+		return snakeCase(key)
+	case "camel":
+		return camelCase(key)
+	}
+	return key
+}
+
+// splitWords splits a key on separators and case changes, keeping
+// acronyms together: "HTTPStatus_code" -> ["HTTP", "Status", "code"].
+func splitWords(s string) []string {
+	var words []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = nil
+		}
+	}
+	rs := []rune(s)
+	for i, r := range rs {
+		if r == '_' || r == '-' || r == ' ' {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(cur) > 0 {
+			prev := rs[i-1]
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return words
+}
+
+func snakeCase(s string) string {
+	words := splitWords(s)
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+	}
+	return strings.Join(words, "_")
+}
+
+func camelCase(s string) string {
+	words := splitWords(s)
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if i > 0 {
+			rs := []rune(w)
+			rs[0] = unicode.ToUpper(rs[0])
+			w = string(rs)
+		}
+		words[i] = w
+	}
+	return strings.Join(words, "")
+}
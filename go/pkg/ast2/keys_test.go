@@ -0,0 +1,113 @@
+package ast2
+
+import "testing"
+
+func TestKeyCase(t *testing.T) {
+	tests := []struct {
+		key, snake, camel string
+	}{
+		{key: "userID", snake: "user_id", camel: "userId"},
+		{key: "HTTPStatus_code", snake: "http_status_code", camel: "httpStatusCode"},
+		{key: "request-id", snake: "request_id", camel: "requestId"},
+		{key: "id", snake: "id", camel: "id"},
+		{key: "v2Name", snake: "v2_name", camel: "v2Name"},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.key); got != tt.snake {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.key, got, tt.snake)
+		}
+		if got := camelCase(tt.key); got != tt.camel {
+			t.Errorf("camelCase(%q) = %q, want %q", tt.key, got, tt.camel)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	const header = `package p
+
+import "go.uber.org/zap"
+
+`
+	runMigrateCases(t, []migrateCase{{
+		name: "renamed",
+		src: header + `func f() {
+	zap.L().Info("hi", zap.String("uid", "a"), zap.Int("reqCount", 1))
+}
+`,
+		opts: Options{Config: []byte(`{"keyRenames": {"uid": "user_id"}, "keyCase": "snake"}`)},
+		want: []string{`Str("user_id", "a")`, `Int("req_count", 1)`},
+	}, {
+		name: "error field name",
+		src: header + `func f(err error) {
+	zap.L().Error("failed", zap.Error(err))
+}
+`,
+		opts: Options{Config: []byte(`{"errorFieldName": "err"}`)},
+		want: []string{`AnErr("error", errors.Wrap(err, `},
+	}, {
+		name: "error renamed to the error field name",
+		src: header + `func f(err error) {
+	zap.L().Error("failed", zap.Error(err))
+}
+`,
+		opts: Options{Config: []byte(`{"errorFieldName": "err", "keyRenames": {"error": "err"}}`)},
+		want: []string{`.Err(errors.Wrap(err, `}, notWant: []string{"AnErr"},
+	}, {
+		name: "collision",
+		src: header + `func f() {
+	zap.L().Info("hi", zap.String("userId", "a"), zap.String("user_id", "b"))
+}
+`,
+		opts:  Options{Config: []byte(`{"keyCase": "snake"}`)},
+		diags: []string{CategoryKey},
+	}, {
+		name: "dynamic key",
+		src: header + `func f(k string) {
+	zap.L().Info("hi", zap.String(k, "a"))
+}
+`,
+		want:  []string{`Str(k, "a")`},
+		diags: []string{CategoryKey},
+	}, {
+		name: "constant key",
+		src: header + `const (
+	keyUser = "user"
+	keyID   = keyUser
+)
+
+func f() {
+	zap.L().Info("hi", zap.String(keyID, "a"))
+}
+`,
+		want: []string{`Str(keyID, "a")`},
+	}, {
+		name: "constant key not renamed",
+		src: header + `const keyUser = "userId"
+
+func f() {
+	zap.L().Info("hi", zap.String(keyUser, "a"))
+}
+`,
+		opts:  Options{Config: []byte(`{"keyCase": "snake"}`)},
+		want:  []string{`Str(keyUser, "a")`},
+		diags: []string{CategoryKey},
+	}, {
+		name: "constant key collision",
+		src: header + `const keyUser = "user"
+
+func f() {
+	zap.L().Info("hi", zap.String(keyUser, "a"), zap.String("user", "b"))
+}
+`,
+		diags: []string{CategoryKey},
+	}, {
+		name: "shadowed constant",
+		src: header + `const k = "user"
+
+func f(k string) {
+	zap.L().Info("hi", zap.String(k, "a"))
+}
+`,
+		diags: []string{CategoryKey},
+	}})
+}
@@ -0,0 +1,548 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// loggerConstructors are the zap functions returning a logger whose Sync
+// calls the migration drops.
+var loggerConstructors = map[string]bool{
+	"New": true, "NewProduction": true, "NewDevelopment": true, "NewExample": true, "NewNop": true, "L": true, "S": true,
+}
+
+// zapSync returns e if it is a call of the Sync method of a zap logger.
+func (rw *rewriter) zapSync(e ast.Expr) *ast.CallExpr {
+	call, ok := e.(*ast.CallExpr)
+	if !ok || len(call.Args) != 0 {
+		return nil
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Sync" || !rw.isZapLogger(sel.X) {
+		return nil
+	}
+	return call
+}
+
+// isZapLogger reports whether e is a zap logger: utils.Logger, a global
+// logger, a value of a zap logger type with -types, or a variable bound to
+// the result of a zap constructor.
+func (rw *rewriter) isZapLogger(e ast.Expr) bool {
+	if isZapLoggerExpr(e) {
+		return true
+	}
+	if t := rw.typeOf(e); t != nil {
+		return isZapLoggerType(t)
+	}
+	id, ok := e.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	call, ok := boundValue(id).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, "zap") && loggerConstructors[sel.Sel.Name]
+}
+
+// boundValue returns the expression the declaration of id binds it to, or
+// nil if there is none.
+func boundValue(id *ast.Ident) ast.Expr {
+	if id.Obj == nil {
+		return nil
+	}
+	var lhs, rhs []ast.Expr
+	switch d := id.Obj.Decl.(type) {
+	case *ast.AssignStmt:
+		lhs, rhs = d.Lhs, d.Rhs
+	case *ast.ValueSpec:
+		for _, name := range d.Names {
+			lhs = append(lhs, name)
+		}
+		rhs = d.Values
+	}
+	var value ast.Expr
+	for i, l := range lhs {
+		switch {
+		case !isIdent(l, id.Name):
+		case len(rhs) == len(lhs):
+			value = rhs[i]
+		case len(rhs) == 1 && i == 0:
+			// l, err := zap.NewProduction() binds the logger first.
+			value = rhs[0]
+		}
+	}
+	return value
+}
+
+// rewriteSyncStmt removes the statement orig that calls Sync, or with
+// syncCall in the config replaces the call by it, using wrap to build the
+// statement. It returns nil if the statement was removed.
+func (rw *rewriter) rewriteSyncStmt(orig ast.Stmt, wrap func(*ast.CallExpr) ast.Stmt) ast.Stmt {
+	if rw.cfg.syncCall == nil {
+		if !rw.acceptText(orig, "") {
+			return orig
+		}
+		rw.rewritten++
+		rw.lifecycleSite(orig.Pos(), "removed %s", nodeString(rw.fset, orig))
+		return nil
+	}
+	repl := wrap(rw.syncReplacement())
+	if !rw.accept(orig, repl) {
+		return orig
+	}
+	rw.rewritten++
+	rw.lifecycleSite(orig.Pos(), "replaced %s with %s", nodeString(rw.fset, orig), nodeString(rw.fset, repl))
+	return repl
+}
+
+// rewriteSyncExpr rewrites a Sync call whose result is used, which only
+// syncCall in the config can replace.
+func (rw *rewriter) rewriteSyncExpr(call *ast.CallExpr, orig ast.Expr) ast.Expr {
+	if rw.cfg.syncCall == nil {
+		rw.lifecycleSite(call.Pos(), "%s left as is: its result is used; set syncCall to replace it", types.ExprString(call))
+		return orig
+	}
+	repl := rw.syncReplacement()
+	if !rw.accept(orig, repl) {
+		return orig
+	}
+	rw.rewritten++
+	rw.lifecycleSite(call.Pos(), "replaced %s with %s", types.ExprString(call), types.ExprString(repl))
+	return repl
+}
+
+func (rw *rewriter) syncReplacement() *ast.CallExpr {
+	c, _ := cloneExpr(rw.cfg.syncCall, nil)
+	return c.(*ast.CallExpr)
+}
+
+func allBlank(es []ast.Expr) bool {
+	for _, e := range es {
+		if !isIdent(e, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmptyFuncCall reports whether call calls a function literal without
+// arguments or statements, as defer func() { _ = l.Sync() }() is once its
+// Sync call is removed.
+func isEmptyFuncCall(call *ast.CallExpr) bool {
+	lit, ok := call.Fun.(*ast.FuncLit)
+	return ok && len(call.Args) == 0 && len(lit.Body.List) == 0
+}
+
+func (rw *rewriter) lifecycleSite(pos token.Pos, format string, args ...interface{}) {
+	rw.report.lifecycle = append(rw.report.lifecycle, site{pos: rw.fset.Position(pos), call: fmt.Sprintf(format, args...)})
+}
+
+// reportSharedAtomicLevels reports the AtomicLevels of f that
+// rewriteAtomicLevels leaves alone because they are not local variables:
+// package variables, values assigned to fields or passed around, and
+// struct fields of type zap.AtomicLevel. Their level is shared beyond one
+// function, so setting the zerolog level in their place is left to hand.
+// Those in ignored function statements are reported by ignoreNode.
+func (rw *rewriter) reportSharedAtomicLevels(f *ast.File) {
+	local := make(map[ast.Expr]bool)
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.AssignStmt:
+				if x.Tok == token.DEFINE && len(x.Lhs) == 1 && len(x.Rhs) == 1 {
+					if _, ok := x.Lhs[0].(*ast.Ident); ok {
+						local[x.Rhs[0]] = true
+					}
+				}
+			case *ast.DeclStmt:
+				if gd, ok := x.Decl.(*ast.GenDecl); ok && gd.Tok == token.VAR && len(gd.Specs) == 1 {
+					if vs := gd.Specs[0].(*ast.ValueSpec); len(vs.Names) == 1 && len(vs.Values) == 1 {
+						local[vs.Values[0]] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	for _, decl := range f.Decls {
+		_, inFunc := decl.(*ast.FuncDecl)
+		names := make(map[ast.Expr]string)
+		ast.Inspect(decl, func(n ast.Node) bool {
+			var what string
+			switch x := n.(type) {
+			case *ast.ValueSpec:
+				for i, v := range x.Values {
+					if i < len(x.Names) && !inFunc {
+						names[v] = "package variable " + x.Names[i].Name
+					}
+				}
+				return true
+			case *ast.CallExpr:
+				if !isPkgCall(x, "zap", "NewAtomicLevel") && !isPkgCall(x, "zap", "NewAtomicLevelAt") || local[x] {
+					return true
+				}
+				what = names[x]
+				if what == "" {
+					what = types.ExprString(x)
+				}
+			case *ast.StructType:
+				for _, field := range x.Fields.List {
+					if !isAtomicLevelType(field.Type) {
+						continue
+					}
+					names := []string{"AtomicLevel"}
+					if len(field.Names) > 0 {
+						names = nil
+						for _, id := range field.Names {
+							names = append(names, id.Name)
+						}
+					}
+					for _, name := range names {
+						rw.sharedAtomicLevel(field.Pos(), inFunc, "struct field "+name)
+					}
+				}
+				return true
+			default:
+				return true
+			}
+			rw.sharedAtomicLevel(n.Pos(), inFunc, what)
+			return true
+		})
+	}
+}
+
+// sharedAtomicLevel reports the AtomicLevel what, found at pos, that is
+// left as is.
+func (rw *rewriter) sharedAtomicLevel(pos token.Pos, inFunc bool, what string) {
+	if !rw.isIgnoredPos(pos) {
+		rw.lifecycleSite(pos, "AtomicLevel %s is shared beyond a function and left as is; set the zerolog level by hand", what)
+	} else if !inFunc {
+		rw.report.ignored = append(rw.report.ignored, site{pos: rw.fset.Position(pos), call: "AtomicLevel " + what})
+	}
+}
+
+// isAtomicLevelType reports whether the type expression t is
+// zap.AtomicLevel or a pointer to it.
+func isAtomicLevelType(t ast.Expr) bool {
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	sel, ok := t.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, "zap") && sel.Sel.Name == "AtomicLevel"
+}
+
+// atomicLevel is a variable bound to zap.NewAtomicLevel or
+// zap.NewAtomicLevelAt in the function being rewritten.
+type atomicLevel struct {
+	id      *ast.Ident
+	decl    ast.Stmt
+	initial ast.Expr              // zerolog level it starts at
+	repl    map[ast.Node]ast.Node // replacement of each use
+	blocked []site                // uses that have no zerolog equivalent
+	served  bool                  // used as an http.Handler
+}
+
+// rewriteAtomicLevels rewrites the AtomicLevel variables of body whose
+// every use has a zerolog equivalent: the declaration sets the level of
+// zerolog, or of levelLogger in the config, and the methods get and set
+// it. An AtomicLevel served over HTTP is replaced by the handler of the
+// level helper. Variables with other uses, such as in a zap core, are
+// reported and left as is. It reports whether body changed.
+func (rw *rewriter) rewriteAtomicLevels(body *ast.BlockStmt) bool {
+	levels := make(map[*ast.Object]*atomicLevel)
+	var order []*atomicLevel
+	ast.Inspect(body, func(n ast.Node) bool {
+		var id *ast.Ident
+		var value ast.Expr
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if x.Tok == token.DEFINE && len(x.Lhs) == 1 && len(x.Rhs) == 1 {
+				id, _ = x.Lhs[0].(*ast.Ident)
+				value = x.Rhs[0]
+			}
+		case *ast.DeclStmt:
+			gd, ok := x.Decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.VAR || len(gd.Specs) != 1 {
+				return true
+			}
+			if vs := gd.Specs[0].(*ast.ValueSpec); len(vs.Names) == 1 && len(vs.Values) == 1 {
+				id, value = vs.Names[0], vs.Values[0]
+			}
+		}
+		call, ok := value.(*ast.CallExpr)
+		if id == nil || id.Obj == nil || !ok {
+			return true
+		}
+		al := &atomicLevel{id: id, decl: n.(ast.Stmt), repl: make(map[ast.Node]ast.Node)}
+		switch {
+		case isPkgCall(call, "zap", "NewAtomicLevel") && len(call.Args) == 0:
+			// zap starts at info, zerolog at trace.
+			al.initial = selector("zerolog", "InfoLevel")
+		case isPkgCall(call, "zap", "NewAtomicLevelAt") && len(call.Args) == 1:
+			if al.initial = levelConstant(call.Args[0]); al.initial == nil {
+				al.block(rw, call.Args[0], "its level %s is not a zap level constant", types.ExprString(call.Args[0]))
+			}
+		default:
+			return true
+		}
+		levels[id.Obj] = al
+		order = append(order, al)
+		return true
+	})
+	if len(levels) == 0 {
+		return false
+	}
+
+	var stack []ast.Node
+	ast.Inspect(body, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		stack = append(stack, n)
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Obj == nil || levels[id.Obj] == nil {
+			return true
+		}
+		if al := levels[id.Obj]; id != al.id {
+			rw.levelUse(al, id, func(i int) ast.Node {
+				if len(stack) < i+2 {
+					return nil
+				}
+				return stack[len(stack)-2-i]
+			})
+		}
+		return true
+	})
+
+	modified := false
+	repl := make(map[ast.Node]ast.Node)
+	for _, al := range order {
+		if len(al.blocked) > 0 {
+			rw.report.lifecycle = append(rw.report.lifecycle, al.blocked...)
+			continue
+		}
+		decl := rw.setLevel(al.initial)
+		if rw.isIgnored(al.decl) || !rw.accept(al.decl, decl) {
+			continue
+		}
+		repl[al.decl] = decl
+		for from, to := range al.repl {
+			repl[from] = to
+		}
+		if al.served {
+			rw.levelHelper = true
+		}
+		rw.rewritten++
+		modified = true
+		rw.lifecycleSite(al.decl.Pos(), "AtomicLevel %s replaced by %s and %d use(s) rewritten", al.id.Name, nodeString(rw.fset, decl), len(al.repl))
+	}
+	if modified {
+		astutil.Apply(body, func(c *astutil.Cursor) bool {
+			if to, ok := repl[c.Node()]; ok {
+				c.Replace(to)
+				return false
+			}
+			return true
+		}, nil)
+	}
+	return modified
+}
+
+// levelUse records the rewrite of a use of the AtomicLevel al, or why it
+// has none. parent(i) is the i-th ancestor of id.
+func (rw *rewriter) levelUse(al *atomicLevel, id *ast.Ident, parent func(int) ast.Node) {
+	if sel, ok := parent(0).(*ast.SelectorExpr); ok && sel.X == ast.Expr(id) {
+		call, ok := parent(1).(*ast.CallExpr)
+		if !ok || call.Fun != ast.Expr(sel) {
+			al.block(rw, sel, "%s is used as %s", al.id.Name, types.ExprString(sel))
+			return
+		}
+		switch m := sel.Sel.Name; {
+		case m == "SetLevel" && len(call.Args) == 1:
+			stmt, ok := parent(2).(*ast.ExprStmt)
+			lvl := levelConstant(call.Args[0])
+			if !ok || lvl == nil {
+				al.block(rw, call, "%s: the level is not a zap level constant", types.ExprString(call))
+				return
+			}
+			al.repl[stmt] = rw.setLevel(lvl)
+		case m == "Level" && len(call.Args) == 0:
+			al.repl[call] = rw.getLevel()
+		case m == "String" && len(call.Args) == 0:
+			al.repl[call] = &ast.CallExpr{Fun: &ast.SelectorExpr{X: rw.getLevel(), Sel: ast.NewIdent("String")}}
+		case m == "Enabled" && len(call.Args) == 1:
+			lvl := levelConstant(call.Args[0])
+			if lvl == nil {
+				al.block(rw, call, "%s: the level is not a zap level constant", types.ExprString(call))
+				return
+			}
+			al.repl[call] = &ast.BinaryExpr{X: rw.getLevel(), Op: token.LEQ, Y: lvl}
+		case m == "ServeHTTP":
+			al.served = true
+			al.repl[sel.X] = levelHandlerCall()
+		default:
+			al.block(rw, call, "%s has no zerolog equivalent", types.ExprString(call.Fun))
+		}
+		return
+	}
+	// http.Handle("/level", atom) and the like serve the level.
+	if call, ok := parent(0).(*ast.CallExpr); ok && call.Fun != ast.Expr(id) {
+		name := ""
+		switch fun := call.Fun.(type) {
+		case *ast.SelectorExpr:
+			name = fun.Sel.Name
+		case *ast.Ident:
+			name = fun.Name
+		}
+		if name == "Handle" || name == "ListenAndServe" || name == "ListenAndServeTLS" {
+			al.served = true
+			al.repl[id] = levelHandlerCall()
+			return
+		}
+	}
+	n, _ := parent(0).(ast.Expr)
+	if n == nil {
+		n = id
+	}
+	al.block(rw, id, "%s is used in %s", al.id.Name, types.ExprString(n))
+}
+
+func (al *atomicLevel) block(rw *rewriter, at ast.Node, format string, args ...interface{}) {
+	msg := fmt.Sprintf("AtomicLevel %s left as is: %s", al.id.Name, fmt.Sprintf(format, args...))
+	al.blocked = append(al.blocked, site{pos: rw.fset.Position(at.Pos()), call: msg})
+}
+
+// levelConstant returns the zerolog level for a zap level constant, or
+// nil for other expressions.
+func levelConstant(e ast.Expr) ast.Expr {
+	if lvl := zerologLevel(e); lvl != e {
+		return lvl
+	}
+	return nil
+}
+
+// setLevel returns the statement that sets the level lvl.
+func (rw *rewriter) setLevel(lvl ast.Expr) ast.Stmt {
+	if rw.cfg.levelLogger == nil {
+		return &ast.ExprStmt{X: &ast.CallExpr{Fun: selector("zerolog", "SetGlobalLevel"), Args: []ast.Expr{lvl}}}
+	}
+	logger, _ := cloneExpr(rw.cfg.levelLogger, nil)
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{logger},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{&ast.CallExpr{Fun: &ast.SelectorExpr{X: rw.levelLoggerExpr(), Sel: ast.NewIdent("Level")}, Args: []ast.Expr{lvl}}},
+	}
+}
+
+// getLevel returns the expression of the current level.
+func (rw *rewriter) getLevel() ast.Expr {
+	if rw.cfg.levelLogger == nil {
+		return &ast.CallExpr{Fun: selector("zerolog", "GlobalLevel")}
+	}
+	return &ast.CallExpr{Fun: &ast.SelectorExpr{X: rw.levelLoggerExpr(), Sel: ast.NewIdent("GetLevel")}}
+}
+
+func (rw *rewriter) levelLoggerExpr() ast.Expr {
+	e, _ := cloneExpr(rw.cfg.levelLogger, nil)
+	return e
+}
+
+func levelHandlerCall() ast.Expr {
+	return &ast.CallExpr{Fun: ast.NewIdent("zerologLevelHandler")}
+}
+
+// writeLevelHelper writes the level helper for pkg next to the file at
+// path, unless it is already there.
+func writeLevelHelper(path, pkg string, cfg *config) error {
+	helper := filepath.Join(filepath.Dir(path), "zapmigrate_level.go")
+	abs, _ := filepath.Abs(helper)
+	if _, ok := cfg.written[abs]; ok {
+		return nil
+	}
+	if _, err := os.Stat(helper); err == nil {
+		return nil
+	}
+	get, set, imports := "zerolog.GlobalLevel()", "zerolog.SetGlobalLevel(lvl)", ""
+	if cfg.levelLogger != nil {
+		logger := types.ExprString(cfg.levelLogger)
+		get, set = logger+".GetLevel()", fmt.Sprintf("%s = %s.Level(lvl)", logger, logger)
+		// The helper can refer to log.Logger or to a variable of the
+		// package.
+		if sel, ok := cfg.levelLogger.(*ast.SelectorExpr); ok && isIdent(sel.X, "log") {
+			imports = "\t\"github.com/rs/zerolog/log\"\n"
+		}
+	}
+	src := fmt.Sprintf(levelHelper, pkg, imports, set, get)
+	if err := cfg.output(helper, []byte(src)); err != nil {
+		return fmt.Errorf("writing level helper: %w", err)
+	}
+	return nil
+}
+
+// levelHelper replaces a zap.AtomicLevel served over HTTP.
+const levelHelper = `// Code generated by zapmigrate. DO NOT EDIT.
+
+package %s
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+%s)
+
+// zerologLevelHandler serves the log level like zap.AtomicLevel did: GET
+// reports it as {"level":"info"}, PUT changes it from a body of the same
+// form or, for form requests, from the level value.
+func zerologLevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type payload struct {
+			Level string ` + "`json:\"level\"`" + `
+		}
+		enc := json.NewEncoder(w)
+		fail := func(status int, msg string) {
+			w.WriteHeader(status)
+			enc.Encode(map[string]string{"error": msg})
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req payload
+			if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+				req.Level = r.FormValue("level")
+			} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				fail(http.StatusBadRequest, "Request body must be well-formed JSON: "+err.Error())
+				return
+			}
+			if req.Level == "" {
+				fail(http.StatusBadRequest, "Must specify a logging level.")
+				return
+			}
+			lvl, err := zerolog.ParseLevel(req.Level)
+			if err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+			%s
+		default:
+			fail(http.StatusMethodNotAllowed, "Only GET and PUT are supported.")
+			return
+		}
+		enc.Encode(payload{Level: %s.String()})
+	})
+}
+`
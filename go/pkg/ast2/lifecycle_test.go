@@ -0,0 +1,74 @@
+package ast2
+
+import "testing"
+
+func TestLifecycle(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "sync removed",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	defer zap.L().Sync()
+	zap.L().Info("hi")
+}
+`,
+		want:    []string{`log.Info().Msg("hi")`},
+		notWant: []string{"Sync"},
+		diags:   []string{CategoryLifecycle},
+	}, {
+		name: "local AtomicLevel",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f() {
+	atom := zap.NewAtomicLevel()
+	atom.SetLevel(zap.DebugLevel)
+}
+`,
+		want:  []string{"zerolog.SetGlobalLevel(zerolog.InfoLevel)", "zerolog.SetGlobalLevel(zerolog.DebugLevel)"},
+		diags: []string{CategoryLifecycle},
+	}, {
+		name: "package AtomicLevel",
+		src: `package p
+
+import "go.uber.org/zap"
+
+var atom = zap.NewAtomicLevel()
+
+func f() {
+	atom.SetLevel(zap.DebugLevel)
+}
+`,
+		want:  []string{"var atom = zap.NewAtomicLevel()", "atom.SetLevel(zap.DebugLevel)"},
+		diags: []string{CategoryLifecycle},
+	}, {
+		name: "struct field AtomicLevel",
+		src: `package p
+
+import "go.uber.org/zap"
+
+type S struct {
+	level zap.AtomicLevel
+}
+
+func (s *S) init() {
+	s.level = zap.NewAtomicLevelAt(zap.WarnLevel)
+}
+`,
+		want:  []string{"level zap.AtomicLevel", "zap.NewAtomicLevelAt(zap.WarnLevel)"},
+		diags: []string{CategoryLifecycle, CategoryLifecycle},
+	}, {
+		name: "ignored package AtomicLevel",
+		src: `package p
+
+import "go.uber.org/zap"
+
+//zapmigrate:ignore
+var atom = zap.NewAtomicLevel()
+`,
+		diags: []string{CategoryIgnored},
+	}})
+}
@@ -0,0 +1,333 @@
+package ast2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/imports"
+)
+
+// errNoLoggerField is returned by findLogger for a struct without any
+// zerolog logger field, which -add-logger-field can add.
+var errNoLoggerField = errors.New("has no zerolog logger field")
+
+// structDecl is a struct type declared in the package being migrated.
+type structDecl struct {
+	st      *ast.StructType
+	path    string // file declaring the type
+	zerolog string // name the file imports zerolog by, "" if it does not
+}
+
+// typeIndex holds the struct types of a package by name.
+type typeIndex map[string]*structDecl
+
+func indexStructs(files map[string]*ast.File) typeIndex {
+	ix := make(typeIndex)
+	for path, f := range files {
+		zl := ""
+		if spec := findImport(f, zerologImport.path); spec != nil {
+			zl = importName(spec)
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					ix[ts.Name.Name] = &structDecl{st: st, path: path, zerolog: zl}
+				}
+			}
+		}
+	}
+	return ix
+}
+
+// findLogger returns the selector path from a value of the named type to
+// its zerolog logger field, e.g. [logger] or [Base log]. Embedded structs
+// of the package are followed breadth first, like Go promotes fields.
+func (ix typeIndex) findLogger(name string) ([]string, error) {
+	type item struct {
+		name string
+		path []string
+	}
+	queue := []item{{name: name}}
+	seen := make(map[string]bool)
+	var problem error
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		if seen[it.name] {
+			continue
+		}
+		seen[it.name] = true
+		sd := ix[it.name]
+		if sd == nil {
+			if it.path == nil {
+				return nil, errors.New("is not a struct type declared in this package")
+			}
+			continue
+		}
+		for _, field := range sd.st.Fields.List {
+			typ := field.Type
+			if star, ok := typ.(*ast.StarExpr); ok {
+				typ = star.X
+			}
+			if isZerologLogger(typ, sd.zerolog) {
+				name := "Logger" // embedded zerolog.Logger
+				if len(field.Names) > 0 {
+					name = field.Names[0].Name
+				}
+				return append(append([]string(nil), it.path...), name), nil
+			}
+			if len(field.Names) == 0 {
+				if id, ok := typ.(*ast.Ident); ok {
+					queue = append(queue, item{name: id.Name, path: append(append([]string(nil), it.path...), id.Name)})
+				}
+				continue
+			}
+			if it.path == nil && problem == nil && isZapLogger(typ) {
+				problem = fmt.Errorf("has a zap logger in field %s; change its type to zerolog.Logger", field.Names[0].Name)
+			}
+		}
+	}
+	if problem != nil {
+		return nil, problem
+	}
+	return nil, errNoLoggerField
+}
+
+// isZerologLogger reports whether typ is zerolog.Logger in a file that
+// imports zerolog as pkg.
+func isZerologLogger(typ ast.Expr, pkg string) bool {
+	if pkg == "." {
+		return isIdent(typ, "Logger")
+	}
+	sel, ok := typ.(*ast.SelectorExpr)
+	return ok && pkg != "" && isIdent(sel.X, pkg) && sel.Sel.Name == "Logger"
+}
+
+func isZapLogger(typ ast.Expr) bool {
+	sel, ok := typ.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, "zap") && (sel.Sel.Name == "Logger" || sel.Sel.Name == "SugaredLogger")
+}
+
+// fieldPlan tracks the receiver logger fields of a run across files.
+type fieldPlan struct {
+	add       bool                            // -add-logger-field
+	crossFile bool                            // fields may be added to other files
+	files     map[string]map[string]*ast.File // parsed package files by directory
+	added     map[string]string               // field name added, by "dir type"
+	pending   map[string][]string             // types still to get a field, by file
+	reported  map[string]bool
+	issues    []string // keys of the reported types, in report order
+}
+
+func newFieldPlan() *fieldPlan {
+	return &fieldPlan{
+		files:    make(map[string]map[string]*ast.File),
+		added:    make(map[string]string),
+		pending:  make(map[string][]string),
+		reported: make(map[string]bool),
+	}
+}
+
+// packageTypes indexes the struct types of the package of the file being
+// rewritten, using its current AST for the file itself.
+func (rw *rewriter) packageTypes() typeIndex {
+	if rw.types != nil {
+		return rw.types
+	}
+	abs, _ := filepath.Abs(rw.path)
+	dir := filepath.Dir(abs)
+	plan := rw.cfg.fields
+	cached, ok := plan.files[dir]
+	if !ok {
+		cached = make(map[string]*ast.File)
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		fset := token.NewFileSet()
+		for _, m := range matches {
+			if f, err := parser.ParseFile(fset, m, nil, parser.SkipObjectResolution); err == nil {
+				cached[m] = f
+			}
+		}
+		plan.files[dir] = cached
+	}
+	files := map[string]*ast.File{abs: rw.file}
+	for path, f := range cached {
+		if path != abs && f.Name.Name == rw.file.Name.Name {
+			files[path] = f
+		}
+	}
+	rw.types = indexStructs(files)
+	return rw.types
+}
+
+// receiverLogger returns the selector path from the receiver of fd to its
+// zerolog logger field, or nil if it has none; the calls of the method are
+// then left alone. With -add-logger-field a missing field is added to the
+// struct, possibly in another file of the package.
+func (rw *rewriter) receiverLogger(fd *ast.FuncDecl) []string {
+	plan := rw.cfg.fields
+	name := recvTypeName(fd)
+	abs, _ := filepath.Abs(rw.path)
+	key := filepath.Dir(abs) + " " + name
+	if field, ok := plan.added[key]; ok {
+		return []string{field}
+	}
+	ix := rw.packageTypes()
+	path, err := ix.findLogger(name)
+	if err == nil {
+		return path
+	}
+	if errors.Is(err, errNoLoggerField) && plan.add {
+		sd := ix[name]
+		if sd.path == abs || plan.crossFile {
+			field := freeFieldName(sd.st, "logger", "zlogger")
+			plan.added[key] = field
+			if sd.path == abs {
+				addLoggerField(sd.st, field)
+			} else {
+				plan.pending[sd.path] = append(plan.pending[sd.path], name)
+			}
+			rw.fieldIssue(key, fd, fmt.Sprintf("added field %s zerolog.Logger to %s in %s; set it where %s values are created", field, name, filepath.Base(sd.path), name))
+			return []string{field}
+		}
+		err = fmt.Errorf("%w and is declared in %s, which is not being rewritten", err, filepath.Base(sd.path))
+	} else if errors.Is(err, errNoLoggerField) {
+		err = fmt.Errorf("%w (add one or use -add-logger-field)", err)
+	}
+	rw.fieldIssue(key, fd, fmt.Sprintf("%s %v; its calls were left as is", name, err))
+	return nil
+}
+
+// addPendingFields adds the logger fields that earlier files of the run
+// asked for to the structs of the file being rewritten. It reports whether
+// any was added.
+func (rw *rewriter) addPendingFields() bool {
+	abs, _ := filepath.Abs(rw.path)
+	plan := rw.cfg.fields
+	names := plan.pending[abs]
+	if len(names) == 0 {
+		return false
+	}
+	delete(plan.pending, abs)
+	ix := rw.packageTypes()
+	for _, name := range names {
+		if sd := ix[name]; sd != nil && sd.path == abs {
+			addLoggerField(sd.st, plan.added[filepath.Dir(abs)+" "+name])
+		}
+	}
+	return true
+}
+
+func (rw *rewriter) fieldIssue(key string, fd *ast.FuncDecl, msg string) {
+	if rw.cfg.fields.reported[key] {
+		return
+	}
+	rw.cfg.fields.reported[key] = true
+	rw.cfg.fields.issues = append(rw.cfg.fields.issues, key)
+	rw.report.loggerFields = append(rw.report.loggerFields, site{pos: rw.fset.Position(fd.Pos()), call: msg})
+}
+
+func addLoggerField(st *ast.StructType, name string) {
+	st.Fields.List = append(st.Fields.List, &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent(name)},
+		Type:  selector("zerolog", "Logger"),
+	})
+}
+
+// freeFieldName returns the first of names that st has no field of.
+func freeFieldName(st *ast.StructType, names ...string) string {
+	used := make(map[string]bool)
+	for _, field := range st.Fields.List {
+		for _, id := range field.Names {
+			used[id.Name] = true
+		}
+	}
+	for _, name := range names {
+		if !used[name] {
+			return name
+		}
+	}
+	return names[len(names)-1] + "_"
+}
+
+// loggerSelector returns recv.path..., the expression of a receiver's
+// logger field.
+func loggerSelector(recv string, path []string) ast.Expr {
+	var e ast.Expr = ast.NewIdent(recv)
+	for _, name := range path {
+		e = &ast.SelectorExpr{X: e, Sel: ast.NewIdent(name)}
+	}
+	return e
+}
+
+// addPendingFieldsToFiles adds the logger fields still pending once all
+// files of the run are done: those of files that were rewritten before the
+// field was asked for, or that are not rewritten at all.
+func addPendingFieldsToFiles(cfg *config, rep *report) {
+	paths := make([]string, 0, len(cfg.fields.pending))
+	for path := range cfg.fields.pending {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := addFieldsToFile(path, cfg, rep); err != nil {
+			rep.fileError(path, fmt.Errorf("adding logger fields: %w", err))
+		}
+	}
+}
+
+func addFieldsToFile(path string, cfg *config, rep *report) error {
+	// Start from the file as rewritten earlier in the run, if it was.
+	src, ok := cfg.written[path]
+	if !ok {
+		var err error
+		if src, err = os.ReadFile(path); err != nil {
+			return fmt.Errorf("reading file: %w", err)
+		}
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("parsing file: %w", err)
+	}
+	rw := &rewriter{fset: fset, path: path, file: f, cfg: cfg, report: rep}
+	if !rw.addPendingFields() {
+		return nil
+	}
+	rw.fixImports(f)
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return fmt.Errorf("printing file: %w", err)
+	}
+	out, err := imports.Process(path, buf.Bytes(), &imports.Options{FormatOnly: true, Comments: true, TabIndent: true, TabWidth: 8})
+	if err != nil {
+		return fmt.Errorf("formatting file: %w", err)
+	}
+	return cfg.output(path, out)
+}
+
+// usesUtilsLogger reports whether b logs through utils.Logger.
+func usesUtilsLogger(b *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(b, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && isUtilsLogger(sel.X) && logLevels[sel.Sel.Name] {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
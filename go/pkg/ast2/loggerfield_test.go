@@ -0,0 +1,48 @@
+package ast2
+
+import "testing"
+
+func TestLoggerFields(t *testing.T) {
+	const header = `package p
+
+import (
+	"github.com/rs/zerolog"
+	"utils"
+)
+
+`
+	runMigrateCases(t, []migrateCase{{
+		name: "field of the receiver",
+		src: header + `type S struct{ log zerolog.Logger }
+
+func (s *S) A() { utils.Logger.Info("hi") }
+`,
+		want: []string{`s.log.Info().Msg("hi")`},
+	}, {
+		name: "field of an embedded struct",
+		src: header + `type Base struct{ logger *zerolog.Logger }
+
+type S struct{ Base }
+
+func (s S) A() { utils.Logger.Info("hi") }
+`,
+		want: []string{`s.Base.logger.Info().Msg("hi")`},
+	}, {
+		name: "no field",
+		src: header + `type S struct{}
+
+func (s *S) A() { utils.Logger.Info("hi") }
+`,
+		want:  []string{`utils.Logger.Info("hi")`},
+		diags: []string{CategoryLoggerField},
+	}, {
+		name: "field added",
+		src: header + `type S struct{}
+
+func (s *S) A() { utils.Logger.Info("hi") }
+`,
+		opts:  Options{AddLoggerField: true},
+		want:  []string{"logger zerolog.Logger", `s.logger.Info().Msg("hi")`},
+		diags: []string{CategoryLoggerField},
+	}})
+}
@@ -0,0 +1,381 @@
+package ast2
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+)
+
+// marshalerKind describes a zapcore marshaler interface and its zerolog
+// counterpart.
+type marshalerKind struct {
+	zapMethod  string // MarshalLogObject
+	encoder    string // zapcore.ObjectEncoder
+	zeroMethod string // MarshalZerologObject
+	zeroType   string // zerolog.Event
+	zeroIface  string // zerolog.LogObjectMarshaler
+	methods    map[string]string
+}
+
+var marshalerKinds = []*marshalerKind{
+	{
+		zapMethod:  "MarshalLogObject",
+		encoder:    "ObjectEncoder",
+		zeroMethod: "MarshalZerologObject",
+		zeroType:   "Event",
+		zeroIface:  "LogObjectMarshaler",
+		methods: map[string]string{
+			"AddString":     "Str",
+			"AddByteString": "Bytes",
+			"AddBool":       "Bool",
+			"AddInt":        "Int",
+			"AddInt64":      "Int64",
+			"AddInt32":      "Int32",
+			"AddInt16":      "Int16",
+			"AddInt8":       "Int8",
+			"AddUint":       "Uint",
+			"AddUint64":     "Uint64",
+			"AddUint32":     "Uint32",
+			"AddUint16":     "Uint16",
+			"AddUint8":      "Uint8",
+			"AddFloat64":    "Float64",
+			"AddFloat32":    "Float32",
+			"AddDuration":   "Dur",
+			"AddTime":       "Time",
+			"AddReflected":  "Interface",
+			"AddObject":     "Object",
+			"AddArray":      "Array",
+		},
+	},
+	{
+		zapMethod:  "MarshalLogArray",
+		encoder:    "ArrayEncoder",
+		zeroMethod: "MarshalZerologArray",
+		zeroType:   "Array",
+		zeroIface:  "LogArrayMarshaler",
+		methods: map[string]string{
+			"AppendString":     "Str",
+			"AppendByteString": "Bytes",
+			"AppendBool":       "Bool",
+			"AppendInt":        "Int",
+			"AppendInt64":      "Int64",
+			"AppendInt32":      "Int32",
+			"AppendInt16":      "Int16",
+			"AppendInt8":       "Int8",
+			"AppendUint":       "Uint",
+			"AppendUint64":     "Uint64",
+			"AppendUint32":     "Uint32",
+			"AppendUint16":     "Uint16",
+			"AppendUint8":      "Uint8",
+			"AppendFloat64":    "Float64",
+			"AppendFloat32":    "Float32",
+			"AppendDuration":   "Dur",
+			"AppendTime":       "Time",
+			"AppendReflected":  "Interface",
+			"AppendObject":     "Object",
+		},
+	},
+}
+
+// marshalerOf returns the kind of zapcore marshaler method fd implements,
+// or nil.
+func marshalerOf(fd *ast.FuncDecl) *marshalerKind {
+	if fd.Recv == nil || len(fd.Recv.List) != 1 || fd.Body == nil {
+		return nil
+	}
+	params, results := fd.Type.Params.List, fd.Type.Results
+	if len(params) != 1 || len(params[0].Names) > 1 || results == nil || len(results.List) != 1 || !isIdent(results.List[0].Type, "error") {
+		return nil
+	}
+	sel, ok := params[0].Type.(*ast.SelectorExpr)
+	if !ok || !isIdent(sel.X, "zapcore") {
+		return nil
+	}
+	for _, k := range marshalerKinds {
+		if fd.Name.Name == k.zapMethod && sel.Sel.Name == k.encoder {
+			return k
+		}
+	}
+	return nil
+}
+
+// recvTypeName returns the name of the receiver type of fd.
+func recvTypeName(fd *ast.FuncDecl) string {
+	t := fd.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch x := t.(type) {
+	case *ast.IndexExpr:
+		t = x.X
+	case *ast.IndexListExpr:
+		t = x.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// convertMarshalers returns the source of a zerolog marshaler method for
+// every zapcore marshaler method in f that does not have one yet, to be
+// appended to the file. The zap methods are kept so that code still
+// logging the types with zap keeps compiling. Methods whose body cannot be
+// translated are reported.
+func (rw *rewriter) convertMarshalers(f *ast.File, src []byte) []string {
+	have := make(map[string]bool)
+	for _, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv != nil && len(fd.Recv.List) == 1 {
+			have[recvTypeName(fd)+"."+fd.Name.Name] = true
+		}
+	}
+
+	pkg, _ := nameFor(f, zerologImport)
+	var generated []string
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		k := marshalerOf(fd)
+		if k == nil || have[recvTypeName(fd)+"."+k.zeroMethod] {
+			continue
+		}
+		pos := rw.fset.Position(fd.Pos())
+		name := recvTypeName(fd) + "." + k.zapMethod
+		if rw.ignored[pos.Line] {
+			rw.report.ignored = append(rw.report.ignored, site{pos: pos, call: name})
+			continue
+		}
+		text, err := convertMarshaler(rw.fset, src, fd, k, pkg)
+		if err != nil {
+			rw.report.marshalers = append(rw.report.marshalers, site{pos: pos, call: fmt.Sprintf("%s: %v", name, err)})
+			continue
+		}
+		if !rw.acceptText(fd, text) {
+			continue
+		}
+		rw.rewritten++
+		generated = append(generated, text)
+	}
+	if len(generated) > 0 {
+		ensureImport(rw.fset, f, zerologImport)
+	}
+	return generated
+}
+
+// convertMarshaler returns the source of the zerolog marshaler method
+// equivalent to fd, whose source is in src. pkg is the name the file
+// imports zerolog as.
+func convertMarshaler(fset *token.FileSet, src []byte, fd *ast.FuncDecl, k *marshalerKind, pkg string) (string, error) {
+	tf := fset.File(fd.Pos())
+	text := src[tf.Offset(fd.Pos()):tf.Offset(fd.End())]
+
+	// Work on a copy of the method so the original stays intact.
+	tmp := token.NewFileSet()
+	cf, err := parser.ParseFile(tmp, "", append([]byte("package p\n"), text...), parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+	md := cf.Decls[0].(*ast.FuncDecl)
+
+	mc := &marshalerConv{kind: k, calls: make(map[*ast.Ident]bool)}
+	param := md.Type.Params.List[0]
+	if len(param.Names) == 1 && param.Names[0].Name != "_" {
+		mc.enc = param.Names[0].Name
+	}
+	md.Name = &ast.Ident{NamePos: md.Name.NamePos, Name: k.zeroMethod}
+	at := param.Type.Pos()
+	var typ ast.Expr = &ast.Ident{NamePos: at, Name: k.zeroType}
+	if pkg != "." {
+		typ = &ast.SelectorExpr{X: &ast.Ident{NamePos: at, Name: pkg}, Sel: typ.(*ast.Ident)}
+	}
+	param.Type = &ast.StarExpr{Star: at, X: typ}
+	md.Type.Results = nil
+	if md.Body.List, err = mc.stmts(md.Body.List, true); err != nil {
+		return "", err
+	}
+	if n := len(md.Body.List); n > 0 {
+		// Close the body right after the last statement when the final
+		// return was dropped.
+		md.Body.Rbrace = md.Body.List[n-1].End()
+	}
+
+	// Anything else that uses the encoder has no zerolog equivalent.
+	if mc.enc != "" {
+		ast.Inspect(md.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name == mc.enc && !mc.calls[id] && err == nil {
+				err = fmt.Errorf("%s is used other than to add fields", mc.enc)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// %s implements zerolog.%s.\n", k.zeroMethod, k.zeroIface)
+	if err := format.Node(&buf, tmp, &printer.CommentedNode{Node: md, Comments: cf.Comments}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// marshalerConv translates the body of a zapcore marshaler method.
+type marshalerConv struct {
+	kind  *marshalerKind
+	enc   string              // name of the encoder parameter
+	calls map[*ast.Ident]bool // encoder references that were translated
+}
+
+// stmts translates a statement list. top is set for the method body, whose
+// final return is dropped.
+func (mc *marshalerConv) stmts(list []ast.Stmt, top bool) ([]ast.Stmt, error) {
+	var out []ast.Stmt
+	for i, s := range list {
+		last := top && i == len(list)-1
+		switch x := s.(type) {
+		case *ast.ReturnStmt:
+			// return nil, or return enc.AddObject(...)
+			if len(x.Results) != 1 {
+				return nil, fmt.Errorf("unexpected return")
+			}
+			if call, ok := mc.encCall(x.Results[0]); ok {
+				if err := mc.translate(call); err != nil {
+					return nil, err
+				}
+				out = append(out, &ast.ExprStmt{X: call})
+			} else if !isIdent(x.Results[0], "nil") {
+				return nil, fmt.Errorf("returns an error, which zerolog marshalers cannot report")
+			}
+			if !last {
+				out = append(out, &ast.ReturnStmt{Return: x.Return})
+			}
+			continue
+		case *ast.IfStmt:
+			// if err := enc.AddArray(...); err != nil { return err }
+			if call, ok := mc.checkedCall(x); ok {
+				if err := mc.translate(call); err != nil {
+					return nil, err
+				}
+				out = append(out, &ast.ExprStmt{X: call})
+				continue
+			}
+			if err := mc.block(x.Body); err != nil {
+				return nil, err
+			}
+			for e := x.Else; e != nil; {
+				switch y := e.(type) {
+				case *ast.BlockStmt:
+					if err := mc.block(y); err != nil {
+						return nil, err
+					}
+					e = nil
+				case *ast.IfStmt:
+					if err := mc.block(y.Body); err != nil {
+						return nil, err
+					}
+					e = y.Else
+				}
+			}
+		case *ast.ExprStmt:
+			if call, ok := mc.encCall(x.X); ok {
+				if err := mc.translate(call); err != nil {
+					return nil, err
+				}
+			}
+		case *ast.BlockStmt:
+			if err := mc.block(x); err != nil {
+				return nil, err
+			}
+		case *ast.ForStmt:
+			if err := mc.block(x.Body); err != nil {
+				return nil, err
+			}
+		case *ast.RangeStmt:
+			if err := mc.block(x.Body); err != nil {
+				return nil, err
+			}
+		case *ast.SwitchStmt:
+			if err := mc.clauses(x.Body); err != nil {
+				return nil, err
+			}
+		case *ast.TypeSwitchStmt:
+			if err := mc.clauses(x.Body); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (mc *marshalerConv) block(b *ast.BlockStmt) error {
+	var err error
+	b.List, err = mc.stmts(b.List, false)
+	return err
+}
+
+func (mc *marshalerConv) clauses(b *ast.BlockStmt) error {
+	for _, s := range b.List {
+		if cc, ok := s.(*ast.CaseClause); ok {
+			var err error
+			if cc.Body, err = mc.stmts(cc.Body, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encCall returns e if it is a method call on the encoder.
+func (mc *marshalerConv) encCall(e ast.Expr) (*ast.CallExpr, bool) {
+	call, ok := e.(*ast.CallExpr)
+	if !ok || mc.enc == "" {
+		return nil, false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return call, ok && isIdent(sel.X, mc.enc)
+}
+
+// checkedCall matches if err := enc.Method(...); err != nil { return err }.
+func (mc *marshalerConv) checkedCall(s *ast.IfStmt) (*ast.CallExpr, bool) {
+	as, ok := s.Init.(*ast.AssignStmt)
+	if !ok || len(as.Lhs) != 1 || len(as.Rhs) != 1 || s.Else != nil || len(s.Body.List) != 1 {
+		return nil, false
+	}
+	call, ok := mc.encCall(as.Rhs[0])
+	if !ok {
+		return nil, false
+	}
+	errVar, ok := as.Lhs[0].(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	cond, ok := s.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.NEQ || !isIdent(cond.X, errVar.Name) || !isIdent(cond.Y, "nil") {
+		return nil, false
+	}
+	ret, ok := s.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 || !isIdent(ret.Results[0], errVar.Name) {
+		return nil, false
+	}
+	return call, true
+}
+
+// translate renames an encoder call to the zerolog method.
+func (mc *marshalerConv) translate(call *ast.CallExpr) error {
+	sel := call.Fun.(*ast.SelectorExpr)
+	method, ok := mc.kind.methods[sel.Sel.Name]
+	if !ok {
+		return fmt.Errorf("%s.%s has no zerolog equivalent", mc.enc, sel.Sel.Name)
+	}
+	sel.Sel = &ast.Ident{NamePos: sel.Sel.NamePos, Name: method}
+	mc.calls[sel.X.(*ast.Ident)] = true
+	return nil
+}
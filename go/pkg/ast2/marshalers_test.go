@@ -0,0 +1,90 @@
+package ast2
+
+import "testing"
+
+func TestMarshalers(t *testing.T) {
+	const header = `package p
+
+import "go.uber.org/zap/zapcore"
+
+`
+	runMigrateCases(t, []migrateCase{{
+		name: "object",
+		src: header + `type T struct {
+	id   string
+	tags []string
+}
+
+func (t *T) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", t.id)
+	if len(t.tags) > 0 {
+		enc.AddInt("tags", len(t.tags))
+	}
+	return nil
+}
+`,
+		want: []string{
+			"func (t *T) MarshalZerologObject(enc *zerolog.Event) {",
+			`enc.Str("id", t.id)`,
+			`enc.Int("tags", len(t.tags))`,
+			"func (t *T) MarshalLogObject(enc zapcore.ObjectEncoder) error {",
+		},
+	}, {
+		name: "array with checked calls",
+		src: header + `type L []T
+
+type T struct{}
+
+func (l L) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, t := range l {
+		if err := enc.AppendObject(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+`,
+		want: []string{"func (l L) MarshalZerologArray(enc *zerolog.Array) {", "enc.Object(t)"},
+	}, {
+		name: "already converted",
+		src: header + `type T struct{}
+
+func (T) MarshalLogObject(enc zapcore.ObjectEncoder) error { return nil }
+
+func (T) MarshalZerologObject(e *zerolog.Event) {}
+`,
+		notWant: []string{"implements zerolog"},
+	}, {
+		name: "encoder passed on",
+		src: header + `type T struct{}
+
+func (t T) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return other(enc)
+}
+
+func other(zapcore.ObjectEncoder) error { return nil }
+`,
+		notWant: []string{"MarshalZerologObject"},
+		diags:   []string{CategoryMarshaler},
+	}, {
+		name: "no zerolog equivalent",
+		src: header + `type T struct{}
+
+func (t T) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.OpenNamespace("t")
+	return nil
+}
+`,
+		notWant: []string{"MarshalZerologObject"},
+		diags:   []string{CategoryMarshaler},
+	}, {
+		name: "ignored",
+		src: header + `type T struct{}
+
+//zapmigrate:ignore
+func (t T) MarshalLogObject(enc zapcore.ObjectEncoder) error { return nil }
+`,
+		notWant: []string{"MarshalZerologObject"},
+		diags:   []string{CategoryIgnored},
+	}})
+}
@@ -0,0 +1,102 @@
+package ast2
+
+import (
+	"go/token"
+	"strings"
+)
+
+// report collects what happened to individual call sites during a run.
+type report struct {
+	errors         []site // files that could not be migrated
+	ignored        []site
+	keyIssues      []site
+	ruleConflicts  []site
+	marshalers     []site            // zapcore marshalers that were not converted
+	dynamicFields  []site            // log calls spreading field slices that were left as is
+	fields         []site            // log calls left as is for a field the migrator cannot rewrite
+	templates      []site            // log calls left as is for a helper call that does not fit its template
+	loggerFields   []site            // receiver types whose logger field was missing or added
+	helpers        []site            // packages that need the observer or level helper, from Migrate
+	testOptions    []site            // zaptest and zap.New options dropped from rewritten test loggers
+	verify         []site            // rewritten calls whose logs differ or were not verified
+	lifecycle      []site            // Sync calls and AtomicLevels removed, rewritten or left
+	replaceGlobals []site            // zap.ReplaceGlobals calls of loggers that are not zerolog's
+	globalInstalls map[string][]site // keyed by package directory and name
+}
+
+// site is a single call site referenced by the report.
+type site struct {
+	pos  token.Position
+	call string
+}
+
+// sections returns the site lists of r by name, for merging and caching.
+func (r *report) sections() map[string]*[]site {
+	return map[string]*[]site{
+		"errors":         &r.errors,
+		"ignored":        &r.ignored,
+		"keyIssues":      &r.keyIssues,
+		"ruleConflicts":  &r.ruleConflicts,
+		"marshalers":     &r.marshalers,
+		"dynamicFields":  &r.dynamicFields,
+		"fields":         &r.fields,
+		"templates":      &r.templates,
+		"loggerFields":   &r.loggerFields,
+		"helpers":        &r.helpers,
+		"testOptions":    &r.testOptions,
+		"verify":         &r.verify,
+		"lifecycle":      &r.lifecycle,
+		"replaceGlobals": &r.replaceGlobals,
+	}
+}
+
+// merge appends the sites of other to r.
+func (r *report) merge(other *report) {
+	mine := r.sections()
+	for name, sites := range other.sections() {
+		*mine[name] = append(*mine[name], *sites...)
+	}
+	for pkg, sites := range other.globalInstalls {
+		if r.globalInstalls == nil {
+			r.globalInstalls = make(map[string][]site)
+		}
+		r.globalInstalls[pkg] = append(r.globalInstalls[pkg], sites...)
+	}
+}
+
+// sites returns the sites of r in the form stored in the cache. Global
+// installs are stored under "install " and the package.
+func (r *report) sites() map[string][]cacheSite {
+	out := make(map[string][]cacheSite)
+	add := func(name string, sites []site) {
+		for _, s := range sites {
+			out[name] = append(out[name], cacheSite{Pos: s.pos, Call: s.call})
+		}
+	}
+	for name, sites := range r.sections() {
+		add(name, *sites)
+	}
+	for pkg, sites := range r.globalInstalls {
+		add("install "+pkg, sites)
+	}
+	return out
+}
+
+// setSites is the inverse of sites.
+func (r *report) setSites(cached map[string][]cacheSite) {
+	sections := r.sections()
+	for name, cs := range cached {
+		sites := make([]site, len(cs))
+		for i, s := range cs {
+			sites[i] = site{pos: s.Pos, call: s.Call}
+		}
+		if pkg, ok := strings.CutPrefix(name, "install "); ok {
+			if r.globalInstalls == nil {
+				r.globalInstalls = make(map[string][]site)
+			}
+			r.globalInstalls[pkg] = sites
+		} else if p := sections[name]; p != nil {
+			*p = sites
+		}
+	}
+}
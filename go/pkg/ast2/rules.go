@@ -0,0 +1,406 @@
+package ast2
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ruleSet is the content of a -rules file. Each non-blank line that does
+// not start with # is either an import or a rule:
+//
+//	import "example.com/logfields"
+//	logfields.Tenant($t) -> zap.String("tenant", $t.ID)
+//	utils.Logger.Warn($msg:strlit, $fields...) -> utils.Logger.Info($msg, $fields...)
+//
+// A metavariable $name matches any expression, or only expressions of one
+// kind when written $name:kind. The kinds expr, ident, lit, strlit, intlit
+// and call are syntactic; string and int match expressions of a string or
+// integer type and need -types. $name... as the last argument of a call in
+// a pattern captures the remaining arguments, possibly none, and splices
+// them where the replacement uses $name.... A metavariable used twice in a
+// pattern must match the same expression both times.
+//
+// Rules are applied bottom-up inside function bodies, so a rule sees the
+// rewritten arguments of the call it matches. Imports are added to the
+// files whose rewritten code uses the imported package, under another
+// name if the file already binds the one given.
+type ruleSet struct {
+	rules   []*rule
+	imports []pkgImport
+}
+
+type rule struct {
+	name     string // file:line, used in the report
+	pattern  ast.Expr
+	replace  ast.Expr
+	kinds    map[string]string
+	variadic map[string]bool
+	typed    string // a metavariable of a kind that needs -types, for errors
+}
+
+// metavarRE matches a metavariable with an optional kind. A kind must
+// follow the colon directly, so composite literal keys such as {$k: $v}
+// are not mistaken for one.
+var metavarRE = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)(?::([a-z]+))?`)
+
+// metavarKinds match an expression by its syntax or, for the typed kinds,
+// by its type t, which is nil if it is not known.
+var metavarKinds = map[string]func(e ast.Expr, t types.Type) bool{
+	"expr":  func(ast.Expr, types.Type) bool { return true },
+	"ident": func(e ast.Expr, _ types.Type) bool { _, ok := e.(*ast.Ident); return ok },
+	"lit":   func(e ast.Expr, _ types.Type) bool { _, ok := e.(*ast.BasicLit); return ok },
+	"strlit": func(e ast.Expr, _ types.Type) bool {
+		lit, ok := e.(*ast.BasicLit)
+		return ok && lit.Kind == token.STRING
+	},
+	"intlit": func(e ast.Expr, _ types.Type) bool {
+		lit, ok := e.(*ast.BasicLit)
+		return ok && lit.Kind == token.INT
+	},
+	"call":   func(e ast.Expr, _ types.Type) bool { _, ok := e.(*ast.CallExpr); return ok },
+	"string": func(_ ast.Expr, t types.Type) bool { return hasBasicInfo(t, types.IsString) },
+	"int":    func(_ ast.Expr, t types.Type) bool { return hasBasicInfo(t, types.IsInteger) },
+}
+
+// typedKinds are the kinds matched by type.
+var typedKinds = map[string]bool{"string": true, "int": true}
+
+func hasBasicInfo(t types.Type, info types.BasicInfo) bool {
+	if t == nil {
+		return false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&info != 0
+}
+
+// parseRules parses rules in the format of the -rules file. Rules are
+// named after name and their line.
+func parseRules(name string, data []byte) (*ruleSet, error) {
+	rs := &ruleSet{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := fmt.Sprintf("%s:%d", name, n)
+		if rest, ok := strings.CutPrefix(line, "import "); ok {
+			imp, err := parseRuleImport(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			rs.imports = append(rs.imports, imp)
+			continue
+		}
+		r, err := parseRule(name, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading rules: %w", err)
+	}
+	return rs, nil
+}
+
+// typedRule returns the first rule with a metavariable of a typed kind, or
+// nil if there is none.
+func (rs *ruleSet) typedRule() *rule {
+	for _, r := range rs.rules {
+		if r.typed != "" {
+			return r
+		}
+	}
+	return nil
+}
+
+func parseRuleImport(s string) (pkgImport, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return pkgImport{}, fmt.Errorf("malformed import %q", s)
+	}
+	p, err := strconv.Unquote(fields[len(fields)-1])
+	if err != nil {
+		return pkgImport{}, fmt.Errorf("malformed import path %s", fields[len(fields)-1])
+	}
+	imp := pkgImport{path: p, name: assumedName(p)}
+	if len(fields) == 2 {
+		imp.name = fields[0]
+	}
+	return imp, nil
+}
+
+// parseRule compiles a "pattern -> replacement" line.
+func parseRule(name, line string) (*rule, error) {
+	pat, repl, ok := strings.Cut(line, "->")
+	if !ok {
+		return nil, fmt.Errorf("rule %q has no ->", line)
+	}
+	pat = strings.TrimSpace(pat)
+	r := &rule{name: name, kinds: make(map[string]string), variadic: make(map[string]bool)}
+
+	var err error
+	var bad error
+	src := metavarRE.ReplaceAllStringFunc(pat, func(m string) string {
+		sub := metavarRE.FindStringSubmatch(m)
+		if kind := sub[2]; kind != "" {
+			if metavarKinds[kind] == nil && bad == nil {
+				bad = fmt.Errorf("unknown kind %q of $%s", kind, sub[1])
+			}
+			if prev, ok := r.kinds[sub[1]]; ok && prev != kind && bad == nil {
+				bad = fmt.Errorf("$%s is declared as both %s and %s", sub[1], prev, kind)
+			}
+			r.kinds[sub[1]] = kind
+			if typedKinds[kind] && r.typed == "" {
+				r.typed = m
+			}
+		}
+		return placeholderPrefix + sub[1]
+	})
+	if bad != nil {
+		return nil, bad
+	}
+	if r.pattern, err = parser.ParseExpr(src); err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pat, err)
+	}
+	if _, ok := placeholderName(r.pattern); ok {
+		return nil, fmt.Errorf("pattern %q matches every expression", pat)
+	}
+	if _, err := cloneExpr(r.pattern, nil); err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pat, err)
+	}
+
+	declared := make(map[string]bool)
+	spliced := make(map[*ast.Ident]bool)
+	ast.Inspect(r.pattern, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if name, ok := variadicArg(call); ok {
+				if r.variadic[name] && bad == nil {
+					bad = fmt.Errorf("variadic $%s is captured twice", name)
+				}
+				r.variadic[name] = true
+				spliced[call.Args[len(call.Args)-1].(*ast.Ident)] = true
+			}
+		}
+		if name, ok := placeholderName(n); ok {
+			declared[name] = true
+		}
+		return true
+	})
+	for id := range spliced {
+		name, _ := placeholderName(id)
+		if r.kinds[name] != "" && bad == nil {
+			bad = fmt.Errorf("variadic $%s cannot have a kind", name)
+		}
+	}
+	if err := checkVariadicUse(r.pattern, r.variadic, spliced); err != nil {
+		return nil, err
+	}
+	if bad != nil {
+		return nil, bad
+	}
+
+	repl = strings.TrimSpace(repl)
+	for _, m := range metavarRE.FindAllStringSubmatch(repl, -1) {
+		if m[2] != "" {
+			return nil, fmt.Errorf("replacement %q: kinds are only allowed in the pattern", repl)
+		}
+	}
+	if r.replace, err = parser.ParseExpr(placeholderRE.ReplaceAllString(repl, placeholderPrefix+"$1")); err != nil {
+		return nil, fmt.Errorf("replacement %q: %w", repl, err)
+	}
+	if _, err := cloneExpr(r.replace, nil); err != nil {
+		return nil, fmt.Errorf("replacement %q: %w", repl, err)
+	}
+	spliced = make(map[*ast.Ident]bool)
+	ast.Inspect(r.replace, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if name, ok := variadicArg(call); ok && r.variadic[name] {
+				spliced[call.Args[len(call.Args)-1].(*ast.Ident)] = true
+			}
+		}
+		if name, ok := placeholderName(n); ok && !declared[name] && bad == nil {
+			bad = fmt.Errorf("replacement %q uses $%s, which the pattern does not declare", repl, name)
+		}
+		return true
+	})
+	if bad != nil {
+		return nil, bad
+	}
+	if err := checkVariadicUse(r.replace, r.variadic, spliced); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkVariadicUse reports a variadic metavariable used anywhere but as the
+// spread last argument of a call.
+func checkVariadicUse(e ast.Expr, variadic map[string]bool, spliced map[*ast.Ident]bool) error {
+	var bad error
+	ast.Inspect(e, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || spliced[id] || bad != nil {
+			return bad == nil
+		}
+		if name, ok := placeholderName(id); ok && variadic[name] {
+			bad = fmt.Errorf("variadic $%s must be used as the last argument of a call, as $%s...", name, name)
+		}
+		return true
+	})
+	return bad
+}
+
+// match reports whether e matches the rule's pattern and returns the
+// bound metavariables. typeOf returns the types of expressions for the
+// typed kinds.
+func (r *rule) match(e ast.Expr, typeOf func(ast.Expr) types.Type) (*binding, bool) {
+	b := &binding{
+		exprs:  make(map[string]ast.Expr),
+		lists:  make(map[string][]ast.Expr),
+		spread: make(map[string]bool),
+		typeOf: typeOf,
+	}
+	if !r.matchExpr(r.pattern, e, b) {
+		return nil, false
+	}
+	return b, true
+}
+
+func (r *rule) matchExpr(p, e ast.Expr, b *binding) bool {
+	if p == nil || e == nil {
+		return p == nil && e == nil
+	}
+	if name, ok := placeholderName(p); ok {
+		if kind := r.kinds[name]; kind != "" {
+			var t types.Type
+			if typedKinds[kind] {
+				t = b.typeOf(e)
+			}
+			if !metavarKinds[kind](e, t) {
+				return false
+			}
+		}
+		if prev, ok := b.exprs[name]; ok {
+			return types.ExprString(prev) == types.ExprString(e)
+		}
+		b.exprs[name] = e
+		return true
+	}
+	switch p := p.(type) {
+	case *ast.Ident:
+		x, ok := e.(*ast.Ident)
+		return ok && x.Name == p.Name
+	case *ast.BasicLit:
+		x, ok := e.(*ast.BasicLit)
+		return ok && x.Kind == p.Kind && x.Value == p.Value
+	case *ast.SelectorExpr:
+		x, ok := e.(*ast.SelectorExpr)
+		return ok && x.Sel.Name == p.Sel.Name && r.matchExpr(p.X, x.X, b)
+	case *ast.CallExpr:
+		x, ok := e.(*ast.CallExpr)
+		if !ok || !r.matchExpr(p.Fun, x.Fun, b) {
+			return false
+		}
+		if name, ok := variadicArg(p); ok && r.variadic[name] {
+			fixed := len(p.Args) - 1
+			if len(x.Args) < fixed || !r.matchList(p.Args[:fixed], x.Args[:fixed], b) {
+				return false
+			}
+			b.lists[name] = append([]ast.Expr{}, x.Args[fixed:]...)
+			b.spread[name] = x.Ellipsis.IsValid()
+			return true
+		}
+		return p.Ellipsis.IsValid() == x.Ellipsis.IsValid() && r.matchList(p.Args, x.Args, b)
+	case *ast.IndexExpr:
+		x, ok := e.(*ast.IndexExpr)
+		return ok && r.matchExpr(p.X, x.X, b) && r.matchExpr(p.Index, x.Index, b)
+	case *ast.StarExpr:
+		x, ok := e.(*ast.StarExpr)
+		return ok && r.matchExpr(p.X, x.X, b)
+	case *ast.UnaryExpr:
+		x, ok := e.(*ast.UnaryExpr)
+		return ok && x.Op == p.Op && r.matchExpr(p.X, x.X, b)
+	case *ast.BinaryExpr:
+		x, ok := e.(*ast.BinaryExpr)
+		return ok && x.Op == p.Op && r.matchExpr(p.X, x.X, b) && r.matchExpr(p.Y, x.Y, b)
+	case *ast.ParenExpr:
+		x, ok := e.(*ast.ParenExpr)
+		return ok && r.matchExpr(p.X, x.X, b)
+	case *ast.CompositeLit:
+		x, ok := e.(*ast.CompositeLit)
+		return ok && r.matchExpr(p.Type, x.Type, b) && r.matchList(p.Elts, x.Elts, b)
+	case *ast.KeyValueExpr:
+		x, ok := e.(*ast.KeyValueExpr)
+		return ok && r.matchExpr(p.Key, x.Key, b) && r.matchExpr(p.Value, x.Value, b)
+	case *ast.ArrayType:
+		x, ok := e.(*ast.ArrayType)
+		return ok && r.matchExpr(p.Len, x.Len, b) && r.matchExpr(p.Elt, x.Elt, b)
+	}
+	return false
+}
+
+func (r *rule) matchList(ps, es []ast.Expr, b *binding) bool {
+	if len(ps) != len(es) {
+		return false
+	}
+	for i := range ps {
+		if !r.matchExpr(ps[i], es[i], b) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRules rewrites e with the rule that matches it. When several rules
+// match and disagree on the result, e is left alone and the conflict is
+// reported.
+func (rw *rewriter) applyRules(e ast.Expr) (ast.Expr, bool) {
+	rs := rw.cfg.rules
+	if rs == nil {
+		return nil, false
+	}
+	var out ast.Expr
+	var by []string
+	for _, r := range rs.rules {
+		b, ok := r.match(e, func(e ast.Expr) types.Type { return rw.exprTypes.typeOf(rw.fset, e) })
+		if !ok {
+			continue
+		}
+		repl, err := cloneExpr(r.replace, b)
+		if err != nil {
+			rw.report.errors = append(rw.report.errors, site{pos: rw.fset.Position(e.Pos()), call: fmt.Sprintf("skipping rule %s: %v", r.name, err)})
+			continue
+		}
+		if out != nil && types.ExprString(out) == types.ExprString(repl) {
+			continue
+		}
+		if out == nil {
+			out = repl
+		}
+		by = append(by, r.name)
+	}
+	if out == nil {
+		return nil, false
+	}
+	if len(by) > 1 {
+		rw.report.ruleConflicts = append(rw.report.ruleConflicts, site{
+			pos:  rw.fset.Position(e.Pos()),
+			call: fmt.Sprintf("%s matches rules %s", types.ExprString(e), strings.Join(by, ", ")),
+		})
+		return nil, false
+	}
+	if !rw.accept(e, out) {
+		return nil, false
+	}
+	rw.rewritten++
+	return out, true
+}
@@ -0,0 +1,134 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	const header = `package p
+
+import "go.uber.org/zap"
+
+`
+	runMigrateCases(t, []migrateCase{{
+		name: "field helper",
+		src: header + `type T struct{ ID string }
+
+func f(t T) {
+	zap.L().Info("hi", tenant(t))
+}
+
+func tenant(T) zap.Field { return zap.String("", "") }
+`,
+		opts: Options{Rules: []byte("tenant($t) -> zap.String(\"tenant\", $t.ID)\n")},
+		want: []string{`log.Info().Str("tenant", t.ID).Msg("hi")`},
+	}, {
+		name: "variadic and kinds",
+		src: header + `func f(msg string) {
+	zap.L().Warn("lit", zap.Int("a", 1), zap.Int("b", 2))
+	zap.L().Warn(msg, zap.Int("a", 1))
+}
+`,
+		opts: Options{Rules: []byte("zap.L().Warn($m:strlit, $fs...) -> zap.L().Info($m, $fs...)\n")},
+		want: []string{`log.Info().Int("a", 1).Int("b", 2).Msg("lit")`, `log.Warn().Int("a", 1).Msg(msg)`},
+	}, {
+		name: "repeated metavariable",
+		src: header + `func f(a, b string) {
+	zap.L().Info(a + a)
+	zap.L().Info(a + b)
+}
+`,
+		opts: Options{Rules: []byte("$x + $x -> $x\n")},
+		want: []string{`log.Info().Msg(a)`, `log.Info().Msg(a + b)`},
+	}, {
+		name: "import added",
+		src: header + `func f() {
+	zap.L().Info("hi")
+	old("x")
+}
+
+func old(string) {}
+`,
+		opts: Options{Rules: []byte("import \"example.com/logfields\"\nold($m) -> logfields.New($m)\n")},
+		want: []string{`"example.com/logfields"`, `logfields.New("x")`},
+	}, {
+		name: "conflict",
+		src: header + `func f() {
+	zap.L().Info("hi", old())
+}
+
+func old() zap.Field { return zap.String("", "") }
+`,
+		opts:  Options{Rules: []byte("old() -> zap.String(\"a\", \"\")\nold() -> zap.String(\"b\", \"\")\n")},
+		want:  []string{"old()"},
+		diags: []string{CategoryRuleConflict, CategoryField},
+	}, {
+		name: "agreeing rules",
+		src: header + `func f() {
+	zap.L().Info("hi", old())
+}
+
+func old() zap.Field { return zap.String("", "") }
+`,
+		opts: Options{Rules: []byte("old() -> zap.String(\"a\", \"\")\n# same\nold() -> zap.String(\"a\", \"\")\n")},
+		want: []string{`Str("a", "")`},
+	}, {
+		name: "import removed",
+		src: `package p
+
+import (
+	"oldfields"
+
+	"go.uber.org/zap"
+)
+
+func f(id string) {
+	zap.L().Info("hi", oldfields.Tenant(id))
+}
+`,
+		opts:    Options{Rules: []byte("oldfields.Tenant($t) -> zap.String(\"tenant\", $t)\n")},
+		want:    []string{`log.Info().Str("tenant", id).Msg("hi")`},
+		notWant: []string{"oldfields"},
+	}})
+}
+
+func TestRuleTypedKinds(t *testing.T) {
+	const rules = "zap.L().Warn($m:string, $fs...) -> zap.L().Info($m, $fs...)\nzap.L().Error($m:strlit) -> zap.L().Info($m)\n"
+	if _, _, err := Migrate([]byte("package p\n"), Options{Filename: "testdata/p.go", Rules: []byte(rules)}); err == nil || !strings.Contains(err.Error(), "needs -types") {
+		t.Fatalf("Migrate without -types: got error %v", err)
+	}
+
+	dir := typedPackage(t, map[string]string{"log.go": `package m
+
+import "go.uber.org/zap"
+
+func f(msg string) {
+	zap.L().Warn(msg)
+	zap.L().Warn("lit: " + msg)
+	zap.L().Error(msg)
+	zap.L().Error("lit")
+}
+`})
+	path := filepath.Join(dir, "log.go")
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := Migrate(src, Options{Filename: path, Rules: []byte(rules), Types: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{
+		"log.Info().Msg(msg)",
+		`log.Info().Msg("lit: " + msg)`,
+		"log.Error().Msg(msg)",
+		`log.Info().Msg("lit")`,
+	} {
+		if !strings.Contains(string(out), w) {
+			t.Errorf("output does not contain %s:\n%s", w, out)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package ast2
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+// shimField is a zap field constructor the shim provides: params are its
+// parameters after the key, method the zerolog method it calls on events
+// and contexts alike.
+type shimField struct {
+	params string
+	method string
+	keyed  bool
+}
+
+var shimFields = map[string]shimField{
+	"String":     {params: "val string", method: "Str", keyed: true},
+	"Strings":    {params: "val []string", method: "Strs", keyed: true},
+	"ByteString": {params: "val []byte", method: "Bytes", keyed: true},
+	"Int":        {params: "val int", method: "Int", keyed: true},
+	"Ints":       {params: "val []int", method: "Ints", keyed: true},
+	"Int32":      {params: "val int32", method: "Int32", keyed: true},
+	"Int64":      {params: "val int64", method: "Int64", keyed: true},
+	"Uint":       {params: "val uint", method: "Uint", keyed: true},
+	"Uint32":     {params: "val uint32", method: "Uint32", keyed: true},
+	"Uint64":     {params: "val uint64", method: "Uint64", keyed: true},
+	"Bool":       {params: "val bool", method: "Bool", keyed: true},
+	"Float32":    {params: "val float32", method: "Float32", keyed: true},
+	"Float64":    {params: "val float64", method: "Float64", keyed: true},
+	"Duration":   {params: "val time.Duration", method: "Dur", keyed: true},
+	"Time":       {params: "val time.Time", method: "Time", keyed: true},
+	"Stringer":   {params: "val fmt.Stringer", method: "Stringer", keyed: true},
+	"Any":        {params: "val interface{}", method: "Interface", keyed: true},
+	"Reflect":    {params: "val interface{}", method: "Interface", keyed: true},
+	"Object":     {params: "val zerolog.LogObjectMarshaler", method: "Object", keyed: true},
+	"Array":      {params: "val zerolog.LogArrayMarshaler", method: "Array", keyed: true},
+	"NamedError": {params: "val error", method: "AnErr", keyed: true},
+	"Error":      {params: "err error", method: "Err"},
+}
+
+// shimFuncs are the other package functions the shim provides.
+var shimFuncs = map[string]string{
+	"L": `// L returns the global logger.
+func L() *Logger { return global }`,
+	"ReplaceGlobals": `// ReplaceGlobals replaces the global logger and zerolog's, and returns a
+// function that restores them.
+func ReplaceGlobals(l *Logger) func() {
+	prev, prevLog := global, log.Logger
+	global, log.Logger = l, l.l
+	return func() { global, log.Logger = prev, prevLog }
+}`,
+	"NewNop": `// NewNop returns a logger that writes nothing.
+func NewNop() *Logger { return New(zerolog.Nop()) }`,
+	"NewProduction": `// NewProduction returns a logger writing JSON at info level to stderr.
+func NewProduction() (*Logger, error) {
+	return New(zerolog.New(os.Stderr).Level(zerolog.InfoLevel).With().Timestamp().Logger()), nil
+}`,
+	"NewDevelopment": `// NewDevelopment returns a logger writing human readable lines at debug
+// level to stderr.
+func NewDevelopment() (*Logger, error) {
+	return New(zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()), nil
+}`,
+	"NewExample": `// NewExample returns a logger writing JSON without timestamps to stdout.
+func NewExample() *Logger { return New(zerolog.New(os.Stdout)) }`,
+}
+
+// shimMethods are the *Logger methods the shim provides.
+var shimMethods = map[string]string{
+	"Debug":  levelMethod("Debug", "Debug"),
+	"Info":   levelMethod("Info", "Info"),
+	"Warn":   levelMethod("Warn", "Warn"),
+	"Error":  levelMethod("Error", "Error"),
+	"DPanic": levelMethod("DPanic", "Error"),
+	"Panic":  levelMethod("Panic", "Panic"),
+	"Fatal":  levelMethod("Fatal", "Fatal"),
+	"With": `// With returns a logger that adds fields to every entry.
+func (l *Logger) With(fields ...Field) *Logger {
+	c := l.l.With()
+	for _, f := range fields {
+		c = f.context(c)
+	}
+	return &Logger{l: c.Logger(), name: l.name}
+}`,
+	"Named": `// Named returns a logger that adds its name to every entry.
+func (l *Logger) Named(name string) *Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return &Logger{l: l.l.With().Str("logger", name).Logger(), name: name}
+}`,
+	"Sync": `// Sync does nothing: zerolog writes every entry through.
+func (l *Logger) Sync() error { return nil }`,
+}
+
+func levelMethod(name, level string) string {
+	return fmt.Sprintf(`// %[1]s logs msg with fields at %[3]s level.
+func (l *Logger) %[1]s(msg string, fields ...Field) {
+	e := l.l.%[2]s()
+	for _, f := range fields {
+		f.event(e)
+	}
+	e.Msg(msg)
+}`, name, level, strings.ToLower(level))
+}
+
+// shimUsage is the zap API found in the scanned code.
+type shimUsage struct {
+	funcs   map[string]bool // zap.X, including types
+	methods map[string]bool // names called as methods in files that import zap
+	zapcore []site          // uses of zapcore, whose types the shim does not accept
+}
+
+// scanShimUsage records the zap API used by the Go files of file or dir.
+func scanShimUsage(file, dir string) (*shimUsage, error) {
+	u := &shimUsage{funcs: make(map[string]bool), methods: make(map[string]bool)}
+	if file != "" {
+		return u, u.scanFile(file)
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".go" {
+			if err := u.scanFile(path); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		return nil
+	})
+	return u, err
+}
+
+func (u *shimUsage) scanFile(path string) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return err
+	}
+	if spec := findImport(f, zapModule+"/zapcore"); spec != nil {
+		name := importName(spec)
+		ast.Inspect(f, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok && isIdent(sel.X, name) {
+				u.zapcore = append(u.zapcore, site{pos: fset.Position(sel.Pos()), call: "zapcore." + sel.Sel.Name})
+			}
+			return true
+		})
+	}
+	spec := findImport(f, zapModule)
+	if spec == nil {
+		return nil
+	}
+	name := importName(spec)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if isIdent(x.X, name) {
+				u.funcs[x.Sel.Name] = true
+			}
+		case *ast.CallExpr:
+			if sel, ok := x.Fun.(*ast.SelectorExpr); ok && !isIdent(sel.X, name) {
+				u.methods[sel.Sel.Name] = true
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// generateShim returns the source of the zapshim package for the API in
+// u, and the zap API used that it does not provide.
+func generateShim(u *shimUsage) ([]byte, []string, error) {
+	var decls, missing []string
+	for _, name := range sortedKeys(u.funcs) {
+		switch {
+		case name == "Logger" || name == "Field":
+			// Always declared.
+		case shimFuncs[name] != "":
+			decls = append(decls, shimFuncs[name])
+		case shimFields[name].method != "":
+			decls = append(decls, shimFieldFunc(name, shimFields[name]))
+		default:
+			missing = append(missing, "zap."+name)
+		}
+	}
+	for _, name := range sortedKeys(u.methods) {
+		if m := shimMethods[name]; m != "" {
+			decls = append(decls, m)
+		} else if zapLoggerMethods[name] != "" {
+			missing = append(missing, "Logger."+name)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`// Code generated by zapmigrate. DO NOT EDIT.
+
+// Package zapshim implements the part of the go.uber.org/zap API that the
+// code importing it uses, on top of zerolog, so that the logging backend
+// can be switched before the call sites are migrated.
+`)
+	if len(missing) > 0 {
+		buf.WriteString("//\n// Not provided, left for migration by hand:\n")
+		for _, m := range missing {
+			buf.WriteString("//   - " + m + "\n")
+		}
+	}
+	buf.WriteString("package zapshim\n\nimport (\n")
+	for _, std := range []string{"fmt", "os", "time"} {
+		if strings.Contains(strings.Join(decls, "\n"), std+".") {
+			fmt.Fprintf(&buf, "\t%q\n", std)
+		}
+	}
+	buf.WriteString(`
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Logger is the shim of *zap.Logger.
+type Logger struct {
+	l    zerolog.Logger
+	name string
+}
+
+// New returns a logger writing to l.
+func New(l zerolog.Logger) *Logger { return &Logger{l: l} }
+
+var global = New(log.Logger)
+
+// Field is the shim of zap.Field. It adds itself to a zerolog event or
+// context.
+type Field struct {
+	event   func(*zerolog.Event)
+	context func(zerolog.Context) zerolog.Context
+}
+`)
+	for _, d := range decls {
+		buf.WriteString("\n" + d + "\n")
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("formatting zapshim: %w", err)
+	}
+	return out, missing, nil
+}
+
+func shimFieldFunc(name string, sf shimField) string {
+	params, args := sf.params, "val"
+	if sf.keyed {
+		params, args = "key string, "+params, "key, val"
+	} else {
+		args = "err"
+	}
+	return fmt.Sprintf(`// %[1]s is the shim of zap.%[1]s.
+func %[1]s(%[2]s) Field {
+	return Field{
+		event:   func(e *zerolog.Event) { e.%[3]s(%[4]s) },
+		context: func(c zerolog.Context) zerolog.Context { return c.%[3]s(%[4]s) },
+	}
+}`, name, params, sf.method, args)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeShim generates the shim for the API in u into outDir, and reports
+// the API it does not provide.
+func writeShim(u *shimUsage, outDir string, cfg *config, rep *report) error {
+	src, missing, err := generateShim(u)
+	if err != nil {
+		return err
+	}
+	for _, m := range missing {
+		rep.errors = append(rep.errors, site{pos: token.Position{Filename: outDir}, call: "zapshim does not provide " + m})
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	return cfg.output(filepath.Join(outDir, "zapshim.go"), src)
+}
+
+// reportZapcore reports the uses of zapcore in u: zapcore types do not
+// work with the loggers and fields of the shim, so that code keeps
+// needing zap.
+func (u *shimUsage) reportZapcore(rep *report) {
+	for _, s := range u.zapcore {
+		s.call += " does not work with zapshim loggers and fields"
+		rep.errors = append(rep.errors, s)
+	}
+}
+
+// rewriteShimImport points the zap import of the file at path to the shim
+// at shimPath, keeping the name zap so that no call site changes. It
+// returns nil if the file does not import zap.
+func rewriteShimImport(path string, src []byte, shimPath string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parsing file: %w", err)
+	}
+	changed := false
+	for _, spec := range f.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err != nil || p != zapModule {
+			continue
+		}
+		if spec.Name == nil {
+			spec.Name = &ast.Ident{NamePos: spec.Path.Pos(), Name: "zap"}
+		}
+		spec.Path.Value = strconv.Quote(shimPath)
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, fmt.Errorf("printing file: %w", err)
+	}
+	out, err := imports.Process(path, buf.Bytes(), &imports.Options{FormatOnly: true, Comments: true, TabIndent: true, TabWidth: 8})
+	if err != nil {
+		return nil, fmt.Errorf("formatting file: %w", err)
+	}
+	return out, nil
+}
+
+// shimImports rewrites the zap imports of the Go files of file or dir to
+// shimPath.
+func shimImports(file, dir, shimPath string, cfg *config, rep *report) error {
+	rewrite := func(path string) {
+		src, err := os.ReadFile(path)
+		if err == nil {
+			var out []byte
+			if out, err = rewriteShimImport(path, src, shimPath); err == nil && out != nil {
+				err = cfg.output(path, out)
+			}
+		}
+		if err != nil {
+			rep.fileError(path, err)
+		}
+	}
+	if file != "" {
+		rewrite(file)
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".go" {
+			rewrite(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,209 @@
+package ast2
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShim(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string // in the generated shim
+		missing []string
+		zapcore []string
+	}{{
+		name: "fields and methods",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f(l *zap.Logger) {
+	l.With(zap.String("a", "b")).Info("hi", zap.Error(nil))
+	zap.L().Sync()
+}
+`,
+		want: []string{"func String(key string, val string) Field", "func Error(err error) Field", "func (l *Logger) With(", "func (l *Logger) Info(", "func L() *Logger", "func (l *Logger) Sync() error"},
+	}, {
+		name: "missing API",
+		src: `package p
+
+import "go.uber.org/zap"
+
+func f(l *zap.Logger) {
+	l.Check(zap.InfoLevel, "hi")
+}
+`,
+		missing: []string{"zap.InfoLevel", "Logger.Check"},
+	}, {
+		name: "zapcore",
+		src: `package p
+
+import (
+	"go.uber.org/zap"
+	zc "go.uber.org/zap/zapcore"
+)
+
+func f(core zc.Core) *zap.Logger {
+	return zap.New(core, zap.AddCaller())
+}
+`,
+		zapcore: []string{"zapcore.Core"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "p.go")
+			if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			u, err := scanShimUsage(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, missing, err := generateShim(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parser.ParseFile(token.NewFileSet(), "zapshim.go", out, 0); err != nil {
+				t.Errorf("shim does not parse: %v\n%s", err, out)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("shim does not contain %s:\n%s", w, out)
+				}
+			}
+			for _, m := range tt.missing {
+				if !strings.Contains(strings.Join(missing, " "), m) {
+					t.Errorf("missing API %v does not list %s", missing, m)
+				}
+			}
+			rep := &report{}
+			u.reportZapcore(rep)
+			var got []string
+			for _, s := range rep.errors {
+				got = append(got, strings.Fields(s.call)[0])
+			}
+			if strings.Join(got, " ") != strings.Join(tt.zapcore, " ") {
+				t.Errorf("zapcore uses reported are %v, want %v", got, tt.zapcore)
+			}
+		})
+	}
+}
+
+func TestShimImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go": "package p\n\nimport \"go.uber.org/zap\"\n\nvar _ = zap.L\n",
+		"b.go": "package p\n\nimport z \"go.uber.org/zap\"\n\nvar _ = z.L\n",
+		"c.go": "package p\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := defaultConfig()
+	rep := &report{}
+	if err := shimImports("", dir, "example.com/zapshim", cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.errors) > 0 {
+		t.Fatalf("errors: %v", rep.errors)
+	}
+	want := map[string]string{
+		"a.go": `import zap "example.com/zapshim"`,
+		"b.go": `import z "example.com/zapshim"`,
+	}
+	for name, imp := range want {
+		path, _ := filepath.Abs(filepath.Join(dir, name))
+		if got := string(cfg.written[path]); !strings.Contains(got, imp) {
+			t.Errorf("%s does not contain %s:\n%s", name, imp, got)
+		}
+	}
+	if len(cfg.written) != len(want) {
+		t.Errorf("%d file(s) written, want %d", len(cfg.written), len(want))
+	}
+}
+
+// goOffline runs the go command in dir with modules from the module cache
+// only, and skips the test if a module it needs is not there.
+func goOffline(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOSUMDB", "off")
+	out, err := goCommand(dir, args...)
+	if strings.Contains(string(out), "GOPROXY=off") {
+		t.Skipf("modules not in the module cache:\n%s", out)
+	}
+	if err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// TestShimBuilds builds callers against the shim generated for them, with
+// their zap import pointed at it, and runs them.
+func TestShimBuilds(t *testing.T) {
+	const caller = `package main
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	l := zap.NewExample()
+	defer l.Sync()
+	restore := zap.ReplaceGlobals(l.Named("svc"))
+	defer restore()
+	sub := zap.L().With(zap.String("k", "v")).Named("sub")
+	sub.Info("hi", zap.Int("n", 1), zap.Duration("d", time.Second), zap.Error(errors.New("boom")))
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module m\n\ngo 1.21\n\nrequire github.com/rs/zerolog v1.34.0\nrequire golang.org/x/sys v0.13.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte(caller), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	u, err := scanShimUsage(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shim, missing, err := generateShim(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Fatalf("shim does not provide %v", missing)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "zapshim"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "zapshim", "zapshim.go"), shim, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := rewriteShimImport(path, []byte(caller), "m/zapshim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	goOffline(t, dir, "vet", "./...")
+	logs := goOffline(t, dir, "run", ".")
+	for _, w := range []string{`"k":"v"`, `"logger":"svc.sub"`, `"n":1`, `"error":"boom"`, `"message":"hi"`} {
+		if !strings.Contains(logs, w) {
+			t.Errorf("log does not contain %s:\n%s", w, logs)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strings"
+)
+
+// placeholderPrefix replaces the $ of template placeholders so that
+// templates parse as Go expressions.
+const placeholderPrefix = "zapmigrate__"
+
+var placeholderRE = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// fieldTemplate maps a team helper that returns a zap.Field to a zerolog
+// chain fragment, e.g.
+//
+//	logfields.Tenant($x) -> .Str("tenant", $x.ID)
+type fieldTemplate struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+
+	pkg, fn string
+	params  []string       // placeholder names, in argument order
+	uses    map[string]int // occurrences of each placeholder in the replacement
+	calls   []*tmplCall    // chain fragment, innermost call first
+}
+
+type tmplCall struct {
+	method string
+	args   []ast.Expr
+}
+
+// compile parses the template and checks that the pattern is a helper call
+// whose arguments are distinct placeholders, and that the replacement only
+// uses those placeholders.
+func (t *fieldTemplate) compile() error {
+	pat, err := parser.ParseExpr(placeholderRE.ReplaceAllString(t.Pattern, placeholderPrefix+"$1"))
+	if err != nil {
+		return fmt.Errorf("pattern %q: %w", t.Pattern, err)
+	}
+	call, ok := pat.(*ast.CallExpr)
+	if !ok || call.Ellipsis.IsValid() {
+		return fmt.Errorf("pattern %q is not a helper call", t.Pattern)
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return fmt.Errorf("pattern %q is not a pkg.Func call", t.Pattern)
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return fmt.Errorf("pattern %q is not a pkg.Func call", t.Pattern)
+	}
+	t.pkg, t.fn = pkg.Name, sel.Sel.Name
+
+	declared := make(map[string]bool)
+	t.params = nil
+	for _, arg := range call.Args {
+		name, ok := placeholderName(arg)
+		if !ok {
+			return fmt.Errorf("pattern %q: argument %s is not a $placeholder", t.Pattern, types.ExprString(arg))
+		}
+		if declared[name] {
+			return fmt.Errorf("pattern %q: placeholder $%s is used twice", t.Pattern, name)
+		}
+		declared[name] = true
+		t.params = append(t.params, name)
+	}
+
+	// Parse ".Str(...).Int(...)" as a chain on a dummy receiver.
+	replace := strings.TrimSpace(t.Replace)
+	if !strings.HasPrefix(replace, ".") {
+		return fmt.Errorf("replacement %q must start with a zerolog method, e.g. .Str(...)", t.Replace)
+	}
+	expr, err := parser.ParseExpr(placeholderPrefix + placeholderRE.ReplaceAllString(replace, placeholderPrefix+"$1"))
+	if err != nil {
+		return fmt.Errorf("replacement %q: %w", t.Replace, err)
+	}
+	t.calls = nil
+	for {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			break
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return fmt.Errorf("replacement %q is not a method chain", t.Replace)
+		}
+		t.calls = append([]*tmplCall{{method: sel.Sel.Name, args: call.Args}}, t.calls...)
+		expr = sel.X
+	}
+	if !isIdent(expr, placeholderPrefix) || len(t.calls) == 0 {
+		return fmt.Errorf("replacement %q is not a method chain", t.Replace)
+	}
+
+	t.uses = make(map[string]int)
+	for _, c := range t.calls {
+		for _, arg := range c.args {
+			var bad error
+			ast.Inspect(arg, func(n ast.Node) bool {
+				name, ok := placeholderName(n)
+				if ok && !declared[name] && bad == nil {
+					bad = fmt.Errorf("replacement %q uses $%s, which %s does not declare", t.Replace, name, t.Pattern)
+				}
+				if ok {
+					t.uses[name]++
+				}
+				return bad == nil
+			})
+			if bad != nil {
+				return bad
+			}
+			if _, err := cloneExpr(arg, nil); err != nil {
+				return fmt.Errorf("replacement %q: %w", t.Replace, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether call invokes the template's helper.
+func (t *fieldTemplate) matches(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, t.pkg) && sel.Sel.Name == t.fn
+}
+
+// checkArgs reports an error if call, which matches the template, does not
+// pass an argument for each of its placeholders, or passes one with side
+// effects for a placeholder the replacement repeats.
+func (t *fieldTemplate) checkArgs(call *ast.CallExpr) error {
+	if len(call.Args) != len(t.params) || call.Ellipsis.IsValid() {
+		return fmt.Errorf("takes %d argument(s) in template %q", len(t.params), t.Pattern)
+	}
+	for i, name := range t.params {
+		if t.uses[name] > 1 && !sideEffectFree(call.Args[i]) {
+			return fmt.Errorf("passes %s, which template %q would evaluate %d times", types.ExprString(call.Args[i]), t.Pattern, t.uses[name])
+		}
+	}
+	return nil
+}
+
+// sideEffectFree reports whether evaluating e more than once is the same
+// as evaluating it once: e only reads variables, fields and elements.
+func sideEffectFree(e ast.Expr) bool {
+	switch x := e.(type) {
+	case *ast.Ident, *ast.BasicLit:
+		return true
+	case *ast.SelectorExpr:
+		return sideEffectFree(x.X)
+	case *ast.ParenExpr:
+		return sideEffectFree(x.X)
+	case *ast.StarExpr:
+		return sideEffectFree(x.X)
+	case *ast.UnaryExpr:
+		return x.Op != token.ARROW && sideEffectFree(x.X)
+	case *ast.BinaryExpr:
+		return sideEffectFree(x.X) && sideEffectFree(x.Y)
+	case *ast.IndexExpr:
+		return sideEffectFree(x.X) && sideEffectFree(x.Index)
+	}
+	return false
+}
+
+// apply appends the template's chain fragment to curr, with placeholders
+// bound to the helper call's arguments.
+func (t *fieldTemplate) apply(curr ast.Expr, call *ast.CallExpr) (ast.Expr, error) {
+	if err := t.checkArgs(call); err != nil {
+		return nil, fmt.Errorf("%s %v", types.ExprString(call.Fun), err)
+	}
+	b := &binding{exprs: make(map[string]ast.Expr, len(t.params))}
+	for i, name := range t.params {
+		b.exprs[name] = call.Args[i]
+	}
+	for _, c := range t.calls {
+		args := make([]ast.Expr, len(c.args))
+		for i, arg := range c.args {
+			var err error
+			if args[i], err = cloneExpr(arg, b); err != nil {
+				return nil, err
+			}
+		}
+		curr = &ast.CallExpr{
+			Fun:  &ast.SelectorExpr{X: curr, Sel: ast.NewIdent(c.method)},
+			Args: args,
+		}
+	}
+	return curr, nil
+}
+
+func findTemplate(templates []*fieldTemplate, call *ast.CallExpr) *fieldTemplate {
+	for _, t := range templates {
+		if t.matches(call) {
+			return t
+		}
+	}
+	return nil
+}
+
+// variadicArg returns the name of the placeholder spread as the last
+// argument of call, as in f($args...).
+func variadicArg(call *ast.CallExpr) (string, bool) {
+	if !call.Ellipsis.IsValid() || len(call.Args) == 0 {
+		return "", false
+	}
+	return placeholderName(call.Args[len(call.Args)-1])
+}
+
+func placeholderName(n ast.Node) (string, bool) {
+	id, ok := n.(*ast.Ident)
+	if !ok || !strings.HasPrefix(id.Name, placeholderPrefix) || id.Name == placeholderPrefix {
+		return "", false
+	}
+	return strings.TrimPrefix(id.Name, placeholderPrefix), true
+}
+
+// binding maps placeholders to the expressions they stand for.
+type binding struct {
+	exprs  map[string]ast.Expr
+	lists  map[string][]ast.Expr     // arguments captured by variadic placeholders
+	spread map[string]bool           // the captured arguments ended in ...
+	typeOf func(ast.Expr) types.Type // for the typed kinds of rule metavariables
+}
+
+// cloneExpr copies a template expression without its positions, replacing
+// placeholders with the expressions bound to them. A nil binding only
+// checks that the expression can be cloned.
+func cloneExpr(e ast.Expr, binding *binding) (ast.Expr, error) {
+	if e == nil {
+		return nil, nil
+	}
+	if name, ok := placeholderName(e); ok {
+		if binding == nil {
+			return e, nil
+		}
+		return binding.exprs[name], nil
+	}
+	list := func(es []ast.Expr) ([]ast.Expr, error) {
+		out := make([]ast.Expr, len(es))
+		for i, e := range es {
+			var err error
+			if out[i], err = cloneExpr(e, binding); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	// operand clones an operand of x, parenthesizing a substituted
+	// expression that would otherwise bind differently, e.g. $x.ID with
+	// a + b. Primary operands are those of selectors, calls and the like.
+	operand := func(e ast.Expr, primary bool) (ast.Expr, error) {
+		c, err := cloneExpr(e, binding)
+		if _, ok := placeholderName(e); !ok || err != nil {
+			return c, err
+		}
+		switch c.(type) {
+		case *ast.BinaryExpr:
+			return &ast.ParenExpr{X: c}, nil
+		case *ast.UnaryExpr, *ast.StarExpr:
+			if primary {
+				return &ast.ParenExpr{X: c}, nil
+			}
+		}
+		return c, nil
+	}
+	var err error
+	switch x := e.(type) {
+	case *ast.Ident:
+		return ast.NewIdent(x.Name), nil
+	case *ast.BasicLit:
+		return &ast.BasicLit{Kind: x.Kind, Value: x.Value}, nil
+	case *ast.SelectorExpr:
+		c := &ast.SelectorExpr{Sel: ast.NewIdent(x.Sel.Name)}
+		c.X, err = operand(x.X, true)
+		return c, err
+	case *ast.CallExpr:
+		c := &ast.CallExpr{}
+		if c.Fun, err = operand(x.Fun, true); err != nil {
+			return nil, err
+		}
+		if name, ok := variadicArg(x); ok && binding != nil && binding.lists[name] != nil {
+			// $args... splices the captured arguments.
+			if c.Args, err = list(x.Args[:len(x.Args)-1]); err != nil {
+				return nil, err
+			}
+			c.Args = append(c.Args, binding.lists[name]...)
+			if binding.spread[name] {
+				c.Ellipsis = 1
+			}
+			return c, nil
+		}
+		c.Args, err = list(x.Args)
+		if x.Ellipsis.IsValid() {
+			c.Ellipsis = 1
+		}
+		return c, err
+	case *ast.IndexExpr:
+		c := &ast.IndexExpr{}
+		if c.X, err = operand(x.X, true); err != nil {
+			return nil, err
+		}
+		c.Index, err = cloneExpr(x.Index, binding)
+		return c, err
+	case *ast.StarExpr:
+		c := &ast.StarExpr{}
+		c.X, err = operand(x.X, false)
+		return c, err
+	case *ast.UnaryExpr:
+		c := &ast.UnaryExpr{Op: x.Op}
+		c.X, err = operand(x.X, false)
+		return c, err
+	case *ast.BinaryExpr:
+		c := &ast.BinaryExpr{Op: x.Op}
+		if c.X, err = operand(x.X, false); err != nil {
+			return nil, err
+		}
+		c.Y, err = operand(x.Y, false)
+		return c, err
+	case *ast.ParenExpr:
+		c := &ast.ParenExpr{}
+		c.X, err = cloneExpr(x.X, binding)
+		return c, err
+	case *ast.CompositeLit:
+		c := &ast.CompositeLit{}
+		if c.Type, err = cloneExpr(x.Type, binding); err != nil {
+			return nil, err
+		}
+		c.Elts, err = list(x.Elts)
+		return c, err
+	case *ast.KeyValueExpr:
+		c := &ast.KeyValueExpr{}
+		if c.Key, err = cloneExpr(x.Key, binding); err != nil {
+			return nil, err
+		}
+		c.Value, err = cloneExpr(x.Value, binding)
+		return c, err
+	case *ast.ArrayType:
+		c := &ast.ArrayType{}
+		if c.Len, err = cloneExpr(x.Len, binding); err != nil {
+			return nil, err
+		}
+		c.Elt, err = cloneExpr(x.Elt, binding)
+		return c, err
+	}
+	return nil, fmt.Errorf("unsupported expression %s", types.ExprString(e))
+}
@@ -0,0 +1,172 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const tenantConfig = `{"fieldTemplates": [{"pattern": "logfields.Tenant($t)", "replace": ".Str(\"tenant\", $t.ID).Int(\"tier\", $t.Tier)"}]}`
+
+func TestFieldTemplates(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "expanded",
+		src: `package p
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f(t Tenant) {
+	zap.L().Info("hi", logfields.Tenant(t), zap.Int("n", 1))
+}
+`,
+		opts:    Options{Config: []byte(tenantConfig)},
+		want:    []string{`log.Info().Str("tenant", t.ID).Int("tier", t.Tier).Int("n", 1).Msg("hi")`},
+		notWant: []string{`"logfields"`},
+	}, {
+		name: "helper still used",
+		src: `package p
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f(t Tenant) {
+	zap.L().Info("hi", logfields.Tenant(t))
+	_ = logfields.Default
+}
+`,
+		opts: Options{Config: []byte(tenantConfig)},
+		want: []string{`log.Info().Str("tenant", t.ID).Int("tier", t.Tier).Msg("hi")`, `"logfields"`},
+	}, {
+		// $t appears twice in the replacement, so a call would run twice.
+		name: "repeated placeholder with side effects",
+		src: `package p
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f() {
+	zap.L().Info("hi", logfields.Tenant(nextTenant()))
+	zap.L().Info("hi", logfields.Tenant(tenants[0]))
+}
+`,
+		opts:  Options{Config: []byte(tenantConfig)},
+		want:  []string{`zap.L().Info("hi", logfields.Tenant(nextTenant()))`, `log.Info().Str("tenant", tenants[0].ID).Int("tier", tenants[0].Tier).Msg("hi")`},
+		diags: []string{CategoryTemplate},
+	}, {
+		// A helper called with another number of arguments than its
+		// template declares keeps the call, rather than losing the field.
+		name: "argument count mismatch",
+		src: `package p
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f(t Tenant) {
+	zap.L().Info("hi", logfields.Tenant(t, true), zap.Int("n", 1))
+}
+`,
+		opts:    Options{Config: []byte(tenantConfig)},
+		want:    []string{`zap.L().Info("hi", logfields.Tenant(t, true), zap.Int("n", 1))`},
+		notWant: []string{"zerolog"},
+		diags:   []string{CategoryTemplate},
+	}, {
+		name: "without template",
+		src: `package p
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f(t Tenant) {
+	zap.L().Info("hi", logfields.Tenant(t))
+}
+`,
+		want:  []string{`zap.L().Info("hi", logfields.Tenant(t))`},
+		diags: []string{CategoryField},
+	}})
+}
+
+// TestTemplateHelperArity checks templates against helpers whose declared
+// arity differs from the template's: the calls, which match the helper,
+// cannot fit the template and are left as is.
+func TestTemplateHelperArity(t *testing.T) {
+	dir := typedPackage(t, map[string]string{
+		"log.go": `package m
+
+import (
+	"logfields"
+
+	"go.uber.org/zap"
+)
+
+func f(t logfields.T) {
+	zap.L().Info("hi", logfields.Tenant(t, true))
+	zap.L().Info("hi", logfields.Tags("a"))
+	zap.L().Info("hi", logfields.Tags("a", "b"))
+}
+`,
+	})
+	helpers := filepath.Join(filepath.Dir(dir), "logfields")
+	if err := os.MkdirAll(helpers, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(helpers, "logfields.go"), []byte(`package logfields
+
+import "go.uber.org/zap"
+
+type T struct{ ID string }
+
+func Tenant(t T, verbose bool) zap.Field { return zap.String("tenant", t.ID) }
+
+func Tags(tags ...string) zap.Field { return zap.Any("tags", tags) }
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"fieldTemplates": [
+		{"pattern": "logfields.Tenant($t)", "replace": ".Str(\"tenant\", $t.ID)"},
+		{"pattern": "logfields.Tags($tag)", "replace": ".Strs(\"tags\", []string{$tag})"}
+	]}`
+	path := filepath.Join(dir, "log.go")
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, rep, err := Migrate(src, Options{Filename: path, Config: []byte(config), Types: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{
+		`zap.L().Info("hi", logfields.Tenant(t, true))`,
+		`log.Info().Strs("tags", []string{"a"}).Msg("hi")`,
+		`zap.L().Info("hi", logfields.Tags("a", "b"))`,
+	} {
+		if !strings.Contains(string(out), w) {
+			t.Errorf("output does not contain %s:\n%s", w, out)
+		}
+	}
+	templates := 0
+	for _, d := range rep.Diagnostics {
+		if d.Category == CategoryTemplate {
+			templates++
+		}
+	}
+	if templates != 2 {
+		t.Errorf("%d template diagnostic(s), want 2: %v", templates, rep.Diagnostics)
+	}
+}
@@ -0,0 +1,11 @@
+package zapcore
+
+type ObjectEncoder interface {
+	AddString(key, value string)
+	AddInt(key string, value int)
+	OpenNamespace(key string)
+}
+
+type ArrayEncoder interface {
+	AppendString(value string)
+}
@@ -0,0 +1,45 @@
+package svc
+
+import (
+	"github.com/rs/zerolog"
+	"go.uber.org/zap"
+	"utils"
+)
+
+type Service struct {
+	logger zerolog.Logger
+}
+
+func (s *Service) Handle(id string, err error, v interface{}) {
+	utils.Logger.Info("handling", zap.String("id", id), zap.Int("attempt", 1)) // want `zap call utils.Logger.Info can be rewritten to zerolog`
+	if err != nil {
+		utils.Logger.Error(err.Error()) // want `zap call utils.Logger.Error can be rewritten to zerolog`
+	}
+	utils.Logger.Debug("sizes", zap.Any("n", len(id)), zap.Any("err", err), zap.Any("v", v)) // want `zap call utils.Logger.Debug can be rewritten to zerolog`
+	//zapmigrate:ignore
+	utils.Logger.Warn("kept as is")
+}
+
+func Handle() {
+	utils.Logger.Debug("not a method")
+}
+
+type base struct {
+	log *zerolog.Logger
+}
+
+type Job struct {
+	base
+}
+
+func (j Job) Run() {
+	utils.Logger.Info("running") // want `zap call utils.Logger.Info can be rewritten to zerolog`
+}
+
+type Worker struct {
+	log *zap.Logger
+}
+
+func (w *Worker) Start() {
+	utils.Logger.Info("starting") // want `zap call utils.Logger.Info cannot be rewritten: Worker has a zap logger in field log; change its type to zerolog.Logger`
+}
@@ -0,0 +1,5 @@
+package utils
+
+import "go.uber.org/zap"
+
+var Logger *zap.Logger
@@ -0,0 +1,555 @@
+package ast2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// verifier collects the rewritten call sites of a run, for -verify, and
+// checks by running both that each zerolog chain logs the same level,
+// message and fields as the zap call it replaced.
+type verifier struct {
+	versions map[string]string // module versions the harness requires, by path
+	sites    []verifySite
+	skipped  []site // sites that cannot be run on their own
+
+	ran             bool // the harness built and ran
+	matched, differ int
+}
+
+// verifySite is a call site as a pair of statements of the harness. The
+// values the call takes from its surroundings are replaced by placeholders
+// declared in vars.
+type verifySite struct {
+	pos  token.Position
+	vars []string
+	zap  string  // the original call, on zapLog
+	zero string  // the rewritten chain, on zeroLog
+	cfg  *config // the config of the site's module
+}
+
+func newVerifier() *verifier {
+	return &verifier{versions: map[string]string{
+		zapModule:               "v1.27.0",
+		zerologImport.path:      "v1.34.0",
+		"github.com/pkg/errors": "v0.9.1",
+	}}
+}
+
+// harnessNames are the identifiers a site may use besides its
+// placeholders.
+var harnessNames = map[string]bool{
+	"zapLog": true, "zeroLog": true, "zap": true, "zerolog": true,
+	"errors": true, "time": true, "true": true, "false": true, "nil": true,
+}
+
+// recordVerify adds the rewrite of call to chain to the sites to verify,
+// or reports why it cannot be verified.
+func (rw *rewriter) recordVerify(call *ast.CallExpr, chain ast.Expr) {
+	v := rw.cfg.verify
+	pos := rw.fset.Position(call.Pos())
+	s, err := rw.verifySite(call, chain)
+	if err != nil {
+		v.skipped = append(v.skipped, site{pos: pos, call: fmt.Sprintf("%s not verified: %v", types.ExprString(call.Fun), err)})
+		return
+	}
+	s.pos, s.cfg = pos, rw.cfg
+	v.sites = append(v.sites, s)
+}
+
+func (rw *rewriter) verifySite(call *ast.CallExpr, chain ast.Expr) (verifySite, error) {
+	var s verifySite
+	sel := call.Fun.(*ast.SelectorExpr)
+	switch {
+	case sel.Sel.Name == "Fatal":
+		return s, fmt.Errorf("Fatal exits the program")
+	case !logLevels[sel.Sel.Name] || isGlobalLogger(sel.X) && !isPkgCall(sel.X.(*ast.CallExpr), "zap", "L"):
+		return s, fmt.Errorf("sugared calls are not verified")
+	case len(call.Args) == 0:
+		return s, fmt.Errorf("no message")
+	}
+	root := chainLogger(chain)
+	if root == nil {
+		return s, fmt.Errorf("the rewrite is not a logger chain")
+	}
+
+	repl := map[ast.Expr]ast.Expr{sel.X: ast.NewIdent("zapLog"), root: ast.NewIdent("zeroLog")}
+	allowed := make(map[string]bool)
+	for name := range harnessNames {
+		allowed[name] = true
+	}
+	placeholder := func(e ast.Expr, typ string) error {
+		if _, ok := e.(*ast.BasicLit); ok {
+			return nil
+		}
+		if _, ok := repl[e]; ok {
+			return nil
+		}
+		val := sampleValue(typ, len(s.vars))
+		if val == "" {
+			return fmt.Errorf("no sample value of type %s for %s", typ, types.ExprString(e))
+		}
+		name := fmt.Sprintf("v%d", len(s.vars))
+		s.vars = append(s.vars, fmt.Sprintf("var %s %s = %s", name, typ, val))
+		repl[e] = ast.NewIdent(name)
+		allowed[name] = true
+		return nil
+	}
+
+	msg := call.Args[0]
+	if mcall, ok := msg.(*ast.CallExpr); ok && len(mcall.Args) == 0 {
+		if msel, ok := mcall.Fun.(*ast.SelectorExpr); ok && msel.Sel.Name == "Error" {
+			msg = msel.X
+		}
+	}
+	typ := "string"
+	if msg != call.Args[0] {
+		typ = "error"
+	}
+	if err := placeholder(msg, typ); err != nil {
+		return s, err
+	}
+	for _, field := range call.Args[1:] {
+		fcall, ok := field.(*ast.CallExpr)
+		if !ok {
+			return s, fmt.Errorf("field %s is not a call", types.ExprString(field))
+		}
+		if findTemplate(rw.cfg.FieldTemplates, fcall) != nil {
+			return s, fmt.Errorf("field %s is expanded by a template", types.ExprString(fcall.Fun))
+		}
+		fsel, ok := fcall.Fun.(*ast.SelectorExpr)
+		if !ok || !isIdent(fsel.X, "zap") || zapToZero[fsel.Sel.Name] == "" {
+			return s, fmt.Errorf("field %s is not a zap field constructor", types.ExprString(fcall.Fun))
+		}
+		sf := shimFields[fsel.Sel.Name]
+		_, typ, _ := strings.Cut(sf.params, " ")
+		args := fcall.Args
+		if sf.keyed {
+			if len(args) != 2 {
+				return s, fmt.Errorf("field %s has %d arguments", types.ExprString(fcall.Fun), len(args))
+			}
+			if err := placeholder(args[0], "string"); err != nil {
+				return s, err
+			}
+			args = args[1:]
+		}
+		if len(args) != 1 {
+			return s, fmt.Errorf("field %s has %d arguments", types.ExprString(fcall.Fun), len(fcall.Args))
+		}
+		if typ == "interface{}" {
+			// With -types the chain takes the value by its static type.
+			if t := rw.typeOf(args[0]); t != nil {
+				typ = types.TypeString(types.Default(t), func(p *types.Package) string { return p.Name() })
+			}
+		}
+		if err := placeholder(args[0], typ); err != nil {
+			return s, err
+		}
+	}
+
+	var err error
+	if s.zap, err = rw.printReplaced(call, repl, allowed); err != nil {
+		return s, err
+	}
+	if s.zero, err = rw.printReplaced(chain, repl, allowed); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// chainLogger returns the logger a zerolog chain starts from.
+func chainLogger(chain ast.Expr) ast.Expr {
+	for {
+		call, ok := chain.(*ast.CallExpr)
+		if !ok {
+			return nil
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return nil
+		}
+		if _, ok := sel.X.(*ast.CallExpr); !ok {
+			return sel.X
+		}
+		chain = sel.X
+	}
+}
+
+// printReplaced prints e with the expressions in repl replaced, and
+// restores e. It fails if the result uses a name that is not allowed.
+func (rw *rewriter) printReplaced(e ast.Expr, repl map[ast.Expr]ast.Expr, allowed map[string]bool) (string, error) {
+	back := make(map[ast.Expr]ast.Expr, len(repl))
+	for from, to := range repl {
+		back[to] = from
+	}
+	replaceExprs(e, repl)
+	defer replaceExprs(e, back)
+
+	if name := unknownName(e, allowed); name != "" {
+		return "", fmt.Errorf("the rewrite uses %s", name)
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, rw.fset, e); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func replaceExprs(root ast.Node, repl map[ast.Expr]ast.Expr) {
+	astutil.Apply(root, func(c *astutil.Cursor) bool {
+		if e, ok := c.Node().(ast.Expr); ok {
+			if r, ok := repl[e]; ok {
+				c.Replace(r)
+				return false
+			}
+		}
+		return true
+	}, nil)
+}
+
+// unknownName returns the first identifier of e, other than selected names,
+// that is not allowed.
+func unknownName(e ast.Node, allowed map[string]bool) string {
+	name := ""
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if name == "" {
+				name = unknownName(x.X, allowed)
+			}
+			return false
+		case *ast.Ident:
+			if name == "" && !allowed[x.Name] {
+				name = x.Name
+			}
+		}
+		return name == ""
+	})
+	return name
+}
+
+// sampleValue returns the source of the i-th placeholder value of type
+// typ, or "" if the harness has none.
+func sampleValue(typ string, i int) string {
+	switch typ {
+	case "string":
+		return strconv.Quote(fmt.Sprintf("value %d", i))
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return strconv.Itoa(i + 1)
+	case "float32", "float64":
+		return fmt.Sprintf("%d.5", i+1)
+	case "bool":
+		return "true"
+	case "[]string":
+		return `[]string{"a", "b"}`
+	case "[]int":
+		return "[]int{1, 2}"
+	case "[]byte":
+		return `[]byte("bytes")`
+	case "time.Duration":
+		return fmt.Sprintf("%d * time.Millisecond", 1500*(i+1))
+	case "time.Time":
+		return fmt.Sprintf("time.Date(2024, 1, 2, 3, 4, %d, 0, time.UTC)", i)
+	case "error":
+		return fmt.Sprintf("stderrors.New(%q)", fmt.Sprintf("error %d", i))
+	case "interface{}", "any":
+		return fmt.Sprintf(`map[string]interface{}{"n": %d, "s": "x"}`, i)
+	}
+	return ""
+}
+
+// run builds the harness for the recorded sites in a temporary module,
+// runs it and reports the sites whose logs differ, do not build or were
+// not verifiable.
+func (v *verifier) run(rep *report) {
+	rep.verify = append(rep.verify, v.skipped...)
+	if len(v.sites) == 0 {
+		return
+	}
+	if err := v.runHarness(rep); err != nil {
+		rep.errors = append(rep.errors, site{pos: v.sites[0].pos, call: fmt.Sprintf("verification failed: %v", err)})
+	}
+	sort.SliceStable(rep.verify, func(i, j int) bool {
+		a, b := rep.verify[i].pos, rep.verify[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+}
+
+func (v *verifier) runHarness(rep *report) error {
+	dir, err := os.MkdirTemp("", "zapverify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var mod bytes.Buffer
+	mod.WriteString("module zapverify\n\ngo 1.21\n\nrequire (\n")
+	paths := make([]string, 0, len(v.versions))
+	for path := range v.versions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&mod, "\t%s %s\n", path, v.versions[path])
+	}
+	mod.WriteString(")\n")
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), mod.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	sites := v.sites
+	src, lines := harnessSource(sites)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), src, 0644); err != nil {
+		return err
+	}
+	// Drop the sites that do not build until the rest does. -mod=mod
+	// resolves the dependencies of the harness alone, unlike go mod tidy,
+	// which would fetch those of the modules' tests too.
+	for {
+		out, err := goCommand(dir, "build", "-o", "harness", ".")
+		if err == nil {
+			break
+		}
+		failed := buildFailures(out, lines)
+		if len(failed) == 0 {
+			return fmt.Errorf("go build: %v\n%s", err, out)
+		}
+		var keep []verifySite
+		for i, s := range sites {
+			if msg, ok := failed[i]; ok {
+				rep.verify = append(rep.verify, site{pos: s.pos, call: "rewrite does not build on its own: " + msg})
+			} else {
+				keep = append(keep, s)
+			}
+		}
+		if sites = keep; len(sites) == 0 {
+			return nil
+		}
+		src, lines = harnessSource(sites)
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), src, 0644); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command(filepath.Join(dir, "harness"))
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("running harness: %v", err)
+	}
+	v.ran = true
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(nil, 1<<20)
+	for i := 0; sc.Scan() && i < len(sites); i++ {
+		var logs [2]string
+		if err := json.Unmarshal(sc.Bytes(), &logs); err != nil {
+			return fmt.Errorf("reading harness output: %v", err)
+		}
+		normalize := (&rewriter{cfg: sites[i].cfg}).normalizeKey
+		diffs := compareLogs(logs[0], logs[1], normalize)
+		if len(diffs) == 0 {
+			v.matched++
+			continue
+		}
+		v.differ++
+		rep.verify = append(rep.verify, site{pos: sites[i].pos, call: "logs differ: " + strings.Join(diffs, "; ")})
+	}
+	return sc.Err()
+}
+
+func goCommand(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	return cmd.CombinedOutput()
+}
+
+const harnessHeader = `// Code generated by zapmigrate -verify. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	_ = stderrors.New
+	_ = errors.Wrap
+	_ = time.Second
+)
+
+// newZap returns a zap logger writing production JSON, without
+// timestamps and callers, to w.
+func newZap(w io.Writer) *zap.Logger {
+	enc := zap.NewProductionEncoderConfig()
+	enc.TimeKey = ""
+	enc.CallerKey = ""
+	enc.StacktraceKey = ""
+	return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(enc), zapcore.AddSync(w), zapcore.DebugLevel))
+}
+
+// call runs f, recovering the panic of a Panic level call.
+func call(f func()) {
+	defer func() { recover() }()
+	f()
+}
+`
+
+// harnessSource returns the harness program for sites and the line span
+// of each site's function in it. Each site sets the ErrorFieldName of its
+// module's config.
+func harnessSource(sites []verifySite) ([]byte, [][2]int) {
+	var buf bytes.Buffer
+	buf.WriteString(harnessHeader)
+	lines := make([][2]int, len(sites))
+	for i, s := range sites {
+		buf.WriteString("\n")
+		lines[i][0] = bytes.Count(buf.Bytes(), []byte("\n")) + 1
+		fmt.Fprintf(&buf, "func site%d() [2]string {\n", i)
+		buf.WriteString("\tvar zapBuf, zeroBuf bytes.Buffer\n\tzapLog := newZap(&zapBuf)\n\tzeroLog := zerolog.New(&zeroBuf)\n")
+		for _, v := range s.vars {
+			buf.WriteString("\t" + v + "\n")
+		}
+		fmt.Fprintf(&buf, "\tzerolog.ErrorFieldName = %q\n", s.cfg.ErrorFieldName)
+		fmt.Fprintf(&buf, "\tcall(func() { %s })\n\tcall(func() { %s })\n", s.zap, s.zero)
+		buf.WriteString("\treturn [2]string{zapBuf.String(), zeroBuf.String()}\n}\n")
+		lines[i][1] = bytes.Count(buf.Bytes(), []byte("\n"))
+	}
+	buf.WriteString("\nfunc main() {\n\tout := json.NewEncoder(os.Stdout)\n")
+	buf.WriteString("\tfor _, site := range [](func() [2]string){")
+	for i := range sites {
+		fmt.Fprintf(&buf, "site%d, ", i)
+	}
+	buf.WriteString("} {\n\t\tif err := out.Encode(site()); err != nil {\n\t\t\tpanic(err)\n\t\t}\n\t}\n}\n")
+	return buf.Bytes(), lines
+}
+
+var buildError = regexp.MustCompile(`(?m)^\./main\.go:(\d+):\d+: (.*)$`)
+
+// buildFailures maps the compiler errors in out to the sites whose
+// function they are in.
+func buildFailures(out []byte, lines [][2]int) map[int]string {
+	failed := make(map[int]string)
+	for _, m := range buildError.FindAllSubmatch(out, -1) {
+		line, _ := strconv.Atoi(string(m[1]))
+		i := sort.Search(len(lines), func(i int) bool { return lines[i][1] >= line })
+		if i < len(lines) && lines[i][0] <= line {
+			if _, ok := failed[i]; !ok {
+				failed[i] = string(m[2])
+			}
+		}
+	}
+	return failed
+}
+
+// compareLogs compares a zap JSON entry with a zerolog one and describes
+// their differences. The zap keys are normalized as the migration does.
+func compareLogs(zapOut, zeroOut string, normalize func(string) string) []string {
+	zapEntry, zapErr := decodeEntry(zapOut)
+	zeroEntry, zeroErr := decodeEntry(zeroOut)
+	switch {
+	case zapErr != nil:
+		return []string{"zap: " + zapErr.Error()}
+	case zeroErr != nil:
+		return []string{"zerolog: " + zeroErr.Error()}
+	}
+	var diffs []string
+	pop := func(m map[string]interface{}, key string) interface{} {
+		v := m[key]
+		delete(m, key)
+		return v
+	}
+	if zl, rl := pop(zapEntry, "level"), pop(zeroEntry, zerologLevelField); !reflect.DeepEqual(zl, rl) {
+		diffs = append(diffs, fmt.Sprintf("level: zap %s, zerolog %s", jsonText(zl), jsonText(rl)))
+	}
+	// zerolog leaves out an empty message.
+	zm, rm := pop(zapEntry, "msg"), pop(zeroEntry, zerologMessageField)
+	if rm == nil {
+		rm = ""
+	}
+	if !reflect.DeepEqual(zm, rm) {
+		diffs = append(diffs, fmt.Sprintf("message: zap %s, zerolog %s", jsonText(zm), jsonText(rm)))
+	}
+
+	fields := make(map[string]interface{}, len(zapEntry))
+	for k, v := range zapEntry {
+		fields[normalize(k)] = v
+	}
+	keys := make([]string, 0, len(fields)+len(zeroEntry))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	for k := range zeroEntry {
+		if _, ok := fields[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		zv, inZap := fields[k]
+		rv, inZero := zeroEntry[k]
+		switch {
+		case !inZero:
+			diffs = append(diffs, fmt.Sprintf("field %q only in zap", k))
+		case !inZap:
+			diffs = append(diffs, fmt.Sprintf("field %q only in zerolog", k))
+		case !reflect.DeepEqual(zv, rv):
+			diffs = append(diffs, fmt.Sprintf("field %q: zap %s, zerolog %s", k, jsonText(zv), jsonText(rv)))
+		}
+	}
+	return diffs
+}
+
+// The default zerolog field names, which the harness keeps.
+const (
+	zerologLevelField   = "level"
+	zerologMessageField = "message"
+)
+
+func decodeEntry(out string) (map[string]interface{}, error) {
+	if strings.TrimSpace(out) == "" {
+		return nil, fmt.Errorf("logged nothing")
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &m); err != nil {
+		return nil, fmt.Errorf("logged %q: %v", out, err)
+	}
+	return m, nil
+}
+
+func jsonText(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,172 @@
+package ast2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareLogs(t *testing.T) {
+	tests := []struct {
+		name      string
+		zap, zero string
+		want      []string
+	}{{
+		name: "same",
+		zap:  `{"level":"info","msg":"hi","userId":"a"}`,
+		zero: `{"level":"info","message":"hi","user_id":"a"}`,
+	}, {
+		name: "empty message left out",
+		zap:  `{"level":"info","msg":""}`,
+		zero: `{"level":"info"}`,
+	}, {
+		name: "level and field",
+		zap:  `{"level":"warn","msg":"hi","n":1,"gone":true}`,
+		zero: `{"level":"info","message":"hi","n":"1","new":true}`,
+		want: []string{
+			`level: zap "warn", zerolog "info"`,
+			`field "gone" only in zap`,
+			`field "n": zap 1, zerolog "1"`,
+			`field "new" only in zerolog`,
+		},
+	}, {
+		name: "nothing logged",
+		zap:  `{"level":"info","msg":"hi"}`,
+		want: []string{"zerolog: logged nothing"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareLogs(tt.zap, tt.zero, snakeCase)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("compareLogs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySites(t *testing.T) {
+	const header = `package p
+
+import "go.uber.org/zap"
+
+`
+	tests := []struct {
+		name    string
+		src     string
+		zap     string // the harness statement of the zap call
+		zero    string // and of the chain
+		vars    int
+		skipped string // why the site is not verified
+	}{{
+		name: "placeholders",
+		src:  header + `func f(id string, n int) { zap.L().Info("hi", zap.String("id", id), zap.Int("n", n)) }`,
+		zap:  `zapLog.Info("hi", zap.String("id", v0), zap.Int("n", v1))`,
+		zero: `zeroLog.Info().Str("id", v0).Int("n", v1).Msg("hi")`,
+		vars: 2,
+	}, {
+		name: "error message",
+		src:  header + `func f(err error) { zap.L().Warn(err.Error()) }`,
+		zap:  `zapLog.Warn(v0.Error())`,
+		zero: `zeroLog.Warn().Err(errors.Wrap(v0, "from error")).Msg("")`,
+		vars: 1,
+	}, {
+		name:    "fatal",
+		src:     header + `func f() { zap.L().Fatal("bye") }`,
+		skipped: "Fatal exits the program",
+	}, {
+		name:    "no sample value",
+		src:     header + `type T struct{}` + "\n" + `func f(t T) { zap.L().Info("hi", zap.Object("t", t)) }`,
+		skipped: "no sample value of type zerolog.LogObjectMarshaler for t",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.verify = newVerifier()
+			if _, _, err := processSource("testdata/p.go", []byte(tt.src), cfg, &report{}); err != nil {
+				t.Fatal(err)
+			}
+			v := cfg.verify
+			if tt.skipped != "" {
+				if len(v.skipped) != 1 || !strings.HasSuffix(v.skipped[0].call, tt.skipped) {
+					t.Errorf("skipped = %v, want one for %q", v.skipped, tt.skipped)
+				}
+				return
+			}
+			if len(v.sites) != 1 {
+				t.Fatalf("sites = %v, skipped = %v, want one site", v.sites, v.skipped)
+			}
+			s := v.sites[0]
+			// The harness statements may break lines where the logger
+			// was replaced.
+			if squeeze(s.zap) != squeeze(tt.zap) || squeeze(s.zero) != squeeze(tt.zero) || len(s.vars) != tt.vars {
+				t.Errorf("site = %s / %s with %d var(s), want %s / %s with %d", s.zap, s.zero, len(s.vars), tt.zap, tt.zero, tt.vars)
+			}
+		})
+	}
+}
+
+func squeeze(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// TestRunHarness builds and runs the harness from the module cache, and
+// skips when a module it needs is not there.
+func TestRunHarness(t *testing.T) {
+	const src = `package p
+
+import "go.uber.org/zap"
+
+func f(id string, n int, err error) {
+	zap.L().Info("hi", zap.String("id", id), zap.Int("n", n))
+	zap.L().Warn("failed", zap.Error(err))
+	zap.L().Fatal("bye")
+}
+`
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOSUMDB", "off")
+	cfg := defaultConfig()
+	cfg.verify = newVerifier()
+	// The version zerolog's dependencies need otherwise is not the one
+	// usually cached next to them.
+	cfg.verify.versions["golang.org/x/sys"] = "v0.13.0"
+	rep := &report{}
+	if _, _, err := processSource("testdata/p.go", []byte(src), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	verifyRun(cfg, rep)
+	for _, e := range rep.errors {
+		if strings.Contains(e.call, "GOPROXY=off") {
+			t.Skipf("modules not in the module cache: %s", e.call)
+		}
+	}
+	if len(rep.errors) > 0 {
+		t.Fatalf("errors: %v", rep.errors)
+	}
+	out := rep.export(cfg)
+	if v := out.Verify; v == nil || *v != (VerifyStats{Sites: 2, Matched: 1, Differ: 1}) {
+		t.Errorf("Verify = %+v, want 2 sites, 1 that differs", v)
+	}
+	// zap.Error becomes Err with the error wrapped, which the harness
+	// sees in the message.
+	want := []string{`logs differ: field "error": zap "error 0", zerolog "from error: error 0"`, "Fatal exits the program"}
+	if len(rep.verify) != len(want) {
+		t.Fatalf("verify = %v, want %q", rep.verify, want)
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(rep.verify[i].call, w) {
+			t.Errorf("verify[%d] = %s, want %s", i, rep.verify[i].call, w)
+		}
+	}
+}
+
+func TestVerifyStatsWithoutHarness(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.verify = newVerifier()
+	rep := &report{}
+	if _, _, err := processSource("testdata/p.go", []byte("package p\n\nimport \"go.uber.org/zap\"\n\nfunc f() { zap.L().Fatal(\"bye\") }\n"), cfg, rep); err != nil {
+		t.Fatal(err)
+	}
+	verifyRun(cfg, rep)
+	if v := rep.export(cfg).Verify; v != nil {
+		t.Errorf("Verify = %+v without a harness run, want nil", v)
+	}
+}
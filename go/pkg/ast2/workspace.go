@@ -0,0 +1,245 @@
+package ast2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// moduleConfigFile is the file in a module root whose settings override
+// those of the run for the files of the module, in the format of the
+// -config file.
+const moduleConfigFile = ".zapmigrate.json"
+
+// workspaceModule is a module used by a go.work workspace.
+type workspaceModule struct {
+	path string // module path, from its go.mod
+	dir  string // absolute root directory
+}
+
+// findWorkspace returns the modules of the go.work governing dir, sorted
+// by directory, or nil if there is none or GOWORK is off. Like the go
+// command, it looks for go.work in dir and its parents unless GOWORK names
+// the file.
+func findWorkspace(dir string) ([]*workspaceModule, error) {
+	path := os.Getenv("GOWORK")
+	if path == "off" {
+		return nil, nil
+	}
+	if path == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for d := abs; ; d = filepath.Dir(d) {
+			if _, err := os.Stat(filepath.Join(d, "go.work")); err == nil {
+				path = filepath.Join(d, "go.work")
+				break
+			}
+			if filepath.Dir(d) == d {
+				return nil, nil
+			}
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading go.work: %w", err)
+	}
+	wf, err := modfile.ParseWork(path, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parsing go.work: %w", err)
+	}
+	var mods []*workspaceModule
+	for _, use := range wf.Use {
+		mdir := filepath.FromSlash(use.Path)
+		if !filepath.IsAbs(mdir) {
+			mdir = filepath.Join(filepath.Dir(path), mdir)
+		}
+		if mdir, err = filepath.Abs(mdir); err != nil {
+			return nil, err
+		}
+		gomod, err := os.ReadFile(filepath.Join(mdir, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("go.work: use %s: %w", use.Path, err)
+		}
+		mods = append(mods, &workspaceModule{path: modfile.ModulePath(gomod), dir: mdir})
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].dir < mods[j].dir })
+	return mods, nil
+}
+
+// contains reports whether the absolute path is in the module's tree.
+// Nested modules are not told apart; moduleOf does that.
+func (m *workspaceModule) contains(path string) bool {
+	return path == m.dir || strings.HasPrefix(path, m.dir+string(filepath.Separator))
+}
+
+// moduleOf returns the innermost module of mods containing path, or nil.
+func moduleOf(mods []*workspaceModule, path string) *workspaceModule {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	var best *workspaceModule
+	for _, m := range mods {
+		if m.contains(abs) && (best == nil || len(m.dir) > len(best.dir)) {
+			best = m
+		}
+	}
+	return best
+}
+
+// migrateWorkspace rewrites the Go files under dir module by module, each
+// with its config. Files outside the modules of the workspace are left
+// out, as the go command leaves them out of its builds.
+func migrateWorkspace(dir string, mods []*workspaceModule, cfg *config, rep *report) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range mods {
+		// Walk from the module root if it is under dir, else from dir if
+		// it is inside the module, keeping paths relative to dir.
+		root := dir
+		if rel, err := filepath.Rel(abs, m.dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			root = filepath.Join(dir, rel)
+		} else if moduleOf(mods, abs) != m {
+			continue
+		}
+		cfg.workspace = append(cfg.workspace, m)
+		mcfg, err := cfg.forModule(m)
+		if err != nil {
+			rep.fileError(filepath.Join(m.dir, moduleConfigFile), err)
+			continue
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if cfg.review != nil && cfg.review.quit {
+				return filepath.SkipAll
+			}
+			if info.IsDir() {
+				// Nested modules are processed on their own, if the
+				// workspace uses them.
+				if path != root {
+					if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+						return filepath.SkipDir
+					}
+				}
+				return nil
+			}
+			if filepath.Ext(path) == ".go" {
+				if err := processFile(path, mcfg, rep); err != nil {
+					rep.fileError(path, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("walking module %s: %w", m.path, err)
+		}
+	}
+	return nil
+}
+
+// forModule returns the config for the files of module m: cfg with the
+// settings of the module's config file, if it has one, on top. Settings
+// the file leaves out keep their value for the run, and its keyRenames
+// are added to those of the run. The returned config shares the state of
+// the run with cfg.
+func (cfg *config) forModule(m *workspaceModule) (*config, error) {
+	path := filepath.Join(m.dir, moduleConfigFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading module config: %w", err)
+	}
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	mcfg := *cfg
+	// Decoding into the copy must not reach the maps and templates it
+	// shares with cfg.
+	mcfg.KeyRenames = maps.Clone(cfg.KeyRenames)
+	if _, ok := present["fieldTemplates"]; ok {
+		mcfg.FieldTemplates = nil
+	}
+	if err := json.Unmarshal(data, &mcfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := mcfg.compile(path); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	mcfg.overrides = hex.EncodeToString(sum[:])
+	return &mcfg, nil
+}
+
+// forFile returns the config for the file at path: that of its module if
+// it is in a go.work workspace, else cfg.
+func (cfg *config) forFile(path string) (*config, error) {
+	mods, err := findWorkspace(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	m := moduleOf(mods, path)
+	if m == nil {
+		return cfg, nil
+	}
+	cfg.workspace = []*workspaceModule{m}
+	return cfg.forModule(m)
+}
+
+// writtenModules returns the roots of the workspace modules the run wrote
+// files in.
+func (cfg *config) writtenModules() []string {
+	var roots []string
+	for _, m := range cfg.workspace {
+		for path := range cfg.written {
+			if moduleOf(cfg.workspace, path) == m {
+				roots = append(roots, m.dir)
+				break
+			}
+		}
+	}
+	return roots
+}
+
+// moduleStats breaks the diagnostics and written files of a workspace run
+// down by module, and sets the module of each diagnostic.
+func moduleStats(mods []*workspaceModule, out *Report, written map[string][]byte) {
+	stats := make(map[*workspaceModule]*ModuleStats)
+	for _, m := range mods {
+		stats[m] = &ModuleStats{Path: m.path, Dir: m.dir, Categories: make(map[string]int)}
+	}
+	for path := range written {
+		if m := moduleOf(mods, path); m != nil {
+			stats[m].Files++
+		}
+	}
+	for i, d := range out.Diagnostics {
+		m := moduleOf(mods, d.Pos.Filename)
+		if m == nil {
+			continue
+		}
+		out.Diagnostics[i].Module = m.path
+		stats[m].Categories[d.Category]++
+	}
+	for _, m := range mods {
+		out.Modules = append(out.Modules, *stats[m])
+	}
+}
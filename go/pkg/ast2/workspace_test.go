@@ -0,0 +1,88 @@
+package ast2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	const logCall = `package p
+
+import "go.uber.org/zap"
+
+func f() { zap.L().Info("hi", zap.String("userId", "a")) }
+`
+	files := map[string]string{
+		"go.work":            "go 1.21\n\nuse (\n\t./a\n\t./b\n\t./b/nested\n)\n",
+		"a/go.mod":           "module example.com/a\n",
+		"a/p.go":             logCall,
+		"b/go.mod":           "module example.com/b\n",
+		"b/p.go":             logCall,
+		"b/.zapmigrate.json": `{"keyCase": "snake"}`,
+		"b/nested/go.mod":    "module example.com/b/nested\n",
+		"b/nested/p.go":      logCall,
+		"c/go.mod":           "module example.com/c\n",
+		"c/p.go":             logCall,
+	}
+	tests := []struct {
+		name string
+		dir  string // migrated, relative to the workspace
+		want map[string]string
+	}{{
+		name: "whole workspace",
+		dir:  ".",
+		want: map[string]string{
+			"a/p.go":        `Str("userId", "a")`,
+			"b/p.go":        `Str("user_id", "a")`,
+			"b/nested/p.go": `Str("userId", "a")`,
+			"c/p.go":        "",
+		},
+	}, {
+		name: "inside a module",
+		dir:  "b",
+		want: map[string]string{
+			"b/p.go":        `Str("user_id", "a")`,
+			"b/nested/p.go": `Str("userId", "a")`,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, data := range files {
+				path := filepath.Join(root, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("GOWORK", "")
+			changes, rep, err := MigrateDir(filepath.Join(root, tt.dir), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, c := range changes {
+				rel, _ := filepath.Rel(root, c.Path)
+				got[filepath.ToSlash(rel)] = string(c.Content)
+			}
+			for name, want := range tt.want {
+				out, ok := got[name]
+				switch {
+				case want == "" && ok:
+					t.Errorf("%s outside the workspace modules was migrated", name)
+				case want != "" && !strings.Contains(out, want):
+					t.Errorf("%s does not contain %s:\n%s", name, want, out)
+				}
+			}
+			for _, m := range rep.Modules {
+				if m.Files != 1 {
+					t.Errorf("module %s: %d file(s) written, want 1", m.Path, m.Files)
+				}
+			}
+		})
+	}
+}
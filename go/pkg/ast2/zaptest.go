@@ -0,0 +1,484 @@
+package ast2
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var zapToZeroLevel = map[string]string{
+	"DebugLevel":  "DebugLevel",
+	"InfoLevel":   "InfoLevel",
+	"WarnLevel":   "WarnLevel",
+	"ErrorLevel":  "ErrorLevel",
+	"DPanicLevel": "PanicLevel",
+	"PanicLevel":  "PanicLevel",
+	"FatalLevel":  "FatalLevel",
+}
+
+// testRewrite tracks the loggers and observers created in a test file.
+// Variables are keyed by their declaration, so a parameter or local of the
+// same name in another function is not mistaken for a test logger.
+type testRewrite struct {
+	rw        *rewriter
+	loggers   map[*ast.Object]bool // zerolog loggers created from zaptest or an observer
+	cores     map[*ast.Object]bool // writers returned by newLogObserver
+	logs      map[*ast.Object]bool // *observedLogs returned by newLogObserver
+	done      map[*ast.CallExpr]bool
+	observers bool // the observer helper is needed
+}
+
+// rewriteTestLoggers rewrites zaptest loggers and zaptest/observer
+// assertions in f to zerolog. It reports whether f was modified; the
+// observer helper is requested through rw.observerPkg.
+func (rw *rewriter) rewriteTestLoggers(f *ast.File) bool {
+	if !isImportPresent(f, "go.uber.org/zap/zaptest") && !isImportPresent(f, "go.uber.org/zap/zaptest/observer") {
+		return false
+	}
+	tr := &testRewrite{
+		rw:      rw,
+		loggers: make(map[*ast.Object]bool),
+		cores:   make(map[*ast.Object]bool),
+		logs:    make(map[*ast.Object]bool),
+		done:    make(map[*ast.CallExpr]bool),
+	}
+	used := usedImports(f)
+	modified := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if s, ok := n.(ast.Stmt); ok && rw.isIgnored(s) {
+			return false
+		}
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if tr.track(x) {
+				modified = true
+			}
+		case *ast.CallExpr:
+			if tr.rewriteCall(x) {
+				modified = true
+			}
+		}
+		return true
+	})
+	if !modified {
+		return false
+	}
+
+	rw.fixImports(f)
+	removeUnusedImports(rw.fset, f, used)
+	if tr.observers {
+		rw.observerPkg = f.Name.Name
+	}
+	return true
+}
+
+// track rewrites the logger or observer constructor assigned by as and, if
+// the rewrite was applied, records the variables it binds. It reports
+// whether as was modified.
+func (tr *testRewrite) track(as *ast.AssignStmt) bool {
+	if len(as.Rhs) != 1 {
+		return false
+	}
+	call, ok := as.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	obj := func(i int) *ast.Object {
+		if i >= len(as.Lhs) {
+			return nil
+		}
+		if id, ok := as.Lhs[i].(*ast.Ident); ok && id.Name != "_" {
+			return id.Obj
+		}
+		return nil
+	}
+	record := func(m map[*ast.Object]bool, o *ast.Object) {
+		if o != nil {
+			m[o] = true
+		}
+	}
+	switch {
+	case isPkgCall(call, "zaptest", "NewLogger"):
+		if !tr.rewriteCall(call) {
+			return false
+		}
+		record(tr.loggers, obj(0))
+	case isPkgCall(call, "observer", "New"):
+		if !tr.rewriteCall(call) {
+			return false
+		}
+		record(tr.cores, obj(0))
+		record(tr.logs, obj(1))
+	case isPkgCall(call, "zap", "New"):
+		if !tr.rewriteCall(call) {
+			return false
+		}
+		record(tr.loggers, obj(0))
+	default:
+		return false
+	}
+	return true
+}
+
+// tracked reports whether e is a variable recorded in m.
+func tracked(m map[*ast.Object]bool, e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Obj != nil && m[id.Obj]
+}
+
+// dropOption reports a logger option the zerolog logger has no
+// counterpart for.
+func (tr *testRewrite) dropOption(opt ast.Expr, from string) {
+	tr.rw.report.testOptions = append(tr.rw.report.testOptions, site{
+		pos:  tr.rw.fset.Position(opt.Pos()),
+		call: fmt.Sprintf("%s dropped from %s", nodeString(tr.rw.fset, opt), from),
+	})
+}
+
+func (tr *testRewrite) rewriteCall(call *ast.CallExpr) bool {
+	if tr.done[call] {
+		return false
+	}
+	tr.done[call] = true
+	switch {
+	case isPkgCall(call, "zaptest", "NewLogger") && len(call.Args) > 0:
+		// zaptest.NewLogger(t, opts...) -> zerolog.New(zerolog.NewTestWriter(t))
+		var logger ast.Expr = &ast.CallExpr{
+			Fun: selector("zerolog", "New"),
+			Args: []ast.Expr{&ast.CallExpr{
+				Fun:  selector("zerolog", "NewTestWriter"),
+				Args: []ast.Expr{call.Args[0]},
+			}},
+		}
+		var dropped []ast.Expr
+		for _, opt := range call.Args[1:] {
+			if oc, ok := opt.(*ast.CallExpr); ok && isPkgCall(oc, "zaptest", "Level") && len(oc.Args) == 1 {
+				logger = &ast.CallExpr{
+					Fun:  &ast.SelectorExpr{X: logger, Sel: ast.NewIdent("Level")},
+					Args: []ast.Expr{zerologLevel(oc.Args[0])},
+				}
+				continue
+			}
+			dropped = append(dropped, opt)
+		}
+		if !tr.rw.accept(call, logger) {
+			return false
+		}
+		for _, opt := range dropped {
+			tr.dropOption(opt, "zaptest.NewLogger")
+		}
+		*call = *logger.(*ast.CallExpr)
+		return true
+
+	case isPkgCall(call, "observer", "New") && len(call.Args) == 1:
+		// observer.New(zap.InfoLevel) -> newLogObserver(zerolog.InfoLevel)
+		repl := &ast.CallExpr{Fun: ast.NewIdent("newLogObserver"), Args: []ast.Expr{zerologLevel(call.Args[0])}}
+		if !tr.rw.accept(call, repl) {
+			return false
+		}
+		*call = *repl
+		tr.observers = true
+		return true
+
+	case isPkgCall(call, "zap", "New") && len(call.Args) > 0:
+		// zap.New(core, opts...) -> zerolog.New(core) for observer cores
+		if !tracked(tr.cores, call.Args[0]) {
+			return false
+		}
+		repl := &ast.CallExpr{Fun: selector("zerolog", "New"), Args: call.Args[:1]}
+		if !tr.rw.accept(call, repl) {
+			return false
+		}
+		for _, opt := range call.Args[1:] {
+			tr.dropOption(opt, "zap.New")
+		}
+		*call = *repl
+		return true
+	}
+
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	switch {
+	case tracked(tr.loggers, id) && logLevels[sel.Sel.Name] && len(call.Args) > 0:
+		// logger.Info(msg, fields...) -> logger.Info().Field(...).Msg(msg)
+		if !tr.rw.checkFields(call, call, call.Args[1:]) {
+			return false
+		}
+		chain := zerologChain(ast.NewIdent(id.Name), sel.Sel.Name, call.Args, tr.rw.cfg.FieldTemplates)
+		tr.rw.normalizeChain(chain)
+		if !tr.rw.accept(call, chain) {
+			return false
+		}
+		*call = *chain.(*ast.CallExpr)
+		return true
+
+	case tracked(tr.logs, id) && sel.Sel.Name == "FilterField" && len(call.Args) == 1:
+		// logs.FilterField(zap.String(k, v)) -> logs.FilterField(k, v)
+		fcall, ok := call.Args[0].(*ast.CallExpr)
+		if !ok || len(fcall.Args) != 2 {
+			return false
+		}
+		if fsel, ok := fcall.Fun.(*ast.SelectorExpr); !ok || !isIdent(fsel.X, "zap") {
+			return false
+		}
+		args := append([]ast.Expr(nil), fcall.Args...)
+		if lit, ok := args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			if key, err := strconv.Unquote(lit.Value); err == nil {
+				args[0] = &ast.BasicLit{ValuePos: lit.ValuePos, Kind: token.STRING, Value: strconv.Quote(tr.rw.normalizeKey(key))}
+			}
+		}
+		repl := &ast.CallExpr{Fun: call.Fun, Args: args}
+		if !tr.rw.accept(call, repl) {
+			return false
+		}
+		call.Args = args
+		return true
+	}
+	return false
+}
+
+// zerologLevel maps a zap or zapcore level constant to its zerolog
+// counterpart. Other expressions are returned unchanged.
+func zerologLevel(e ast.Expr) ast.Expr {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok || !(isIdent(sel.X, "zap") || isIdent(sel.X, "zapcore")) {
+		return e
+	}
+	if lvl, ok := zapToZeroLevel[sel.Sel.Name]; ok {
+		return selector("zerolog", lvl)
+	}
+	return e
+}
+
+func isPkgCall(call *ast.CallExpr, pkg, name string) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && sel.Sel.Name == name
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+func selector(x, sel string) *ast.SelectorExpr {
+	return &ast.SelectorExpr{X: ast.NewIdent(x), Sel: ast.NewIdent(sel)}
+}
+
+// usesPackage reports whether f refers to the package imported as name.
+func usesPackage(f *ast.File, name string) bool {
+	used := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if used {
+			return false
+		}
+		if sel, ok := n.(*ast.SelectorExpr); ok && isIdent(sel.X, name) {
+			used = true
+			return false
+		}
+		return true
+	})
+	return used
+}
+
+// writeObserverHelper writes the observer helper for pkg next to the file
+// at path, unless it is already there.
+func writeObserverHelper(path, pkg string, cfg *config) error {
+	name := "zapmigrate_observer_test.go"
+	if strings.HasSuffix(pkg, "_test") {
+		name = "zapmigrate_observer_ext_test.go"
+	}
+	helper := filepath.Join(filepath.Dir(path), name)
+	src := fmt.Sprintf(observerHelper, pkg)
+	if _, err := os.Stat(helper); err == nil {
+		return nil
+	}
+	if err := cfg.output(helper, []byte(src)); err != nil {
+		return fmt.Errorf("writing observer helper: %w", err)
+	}
+	return nil
+}
+
+// observerHelper replaces go.uber.org/zap/zaptest/observer in migrated
+// tests. It captures the JSON lines written by a zerolog.Logger.
+const observerHelper = `// Code generated by zapmigrate. DO NOT EDIT.
+
+package %s
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// logObserver is a zerolog.LevelWriter that records every entry at or
+// above its level.
+type logObserver struct {
+	level zerolog.Level
+	logs  *observedLogs
+}
+
+func newLogObserver(level zerolog.Level) (*logObserver, *observedLogs) {
+	logs := &observedLogs{}
+	return &logObserver{level: level, logs: logs}, logs
+}
+
+func (o *logObserver) Write(p []byte) (int, error) {
+	return o.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (o *logObserver) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level != zerolog.NoLevel && level < o.level {
+		return len(p), nil
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return 0, err
+	}
+	e := observedEntry{Level: level, Fields: fields}
+	if msg, ok := fields[zerolog.MessageFieldName].(string); ok {
+		e.Message = msg
+	}
+	delete(fields, zerolog.MessageFieldName)
+	delete(fields, zerolog.LevelFieldName)
+	o.logs.add(e)
+	return len(p), nil
+}
+
+// observedEntry is a single captured log line.
+type observedEntry struct {
+	Level   zerolog.Level
+	Message string
+	Fields  map[string]interface{}
+}
+
+func (e observedEntry) ContextMap() map[string]interface{} {
+	return e.Fields
+}
+
+// observedLogs is a concurrency-safe list of captured entries.
+type observedLogs struct {
+	mu      sync.Mutex
+	entries []observedEntry
+}
+
+func (o *observedLogs) add(e observedEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = append(o.entries, e)
+}
+
+func (o *observedLogs) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+func (o *observedLogs) All() []observedEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]observedEntry(nil), o.entries...)
+}
+
+func (o *observedLogs) AllUntimed() []observedEntry {
+	all := o.All()
+	for i, e := range all {
+		fields := make(map[string]interface{}, len(e.Fields))
+		for k, v := range e.Fields {
+			if k != zerolog.TimestampFieldName {
+				fields[k] = v
+			}
+		}
+		all[i].Fields = fields
+	}
+	return all
+}
+
+func (o *observedLogs) TakeAll() []observedEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	all := o.entries
+	o.entries = nil
+	return all
+}
+
+func (o *observedLogs) Filter(keep func(observedEntry) bool) *observedLogs {
+	filtered := &observedLogs{}
+	for _, e := range o.All() {
+		if keep(e) {
+			filtered.entries = append(filtered.entries, e)
+		}
+	}
+	return filtered
+}
+
+func (o *observedLogs) FilterMessage(msg string) *observedLogs {
+	return o.Filter(func(e observedEntry) bool { return e.Message == msg })
+}
+
+func (o *observedLogs) FilterMessageSnippet(snippet string) *observedLogs {
+	return o.Filter(func(e observedEntry) bool { return strings.Contains(e.Message, snippet) })
+}
+
+func (o *observedLogs) FilterLevelExact(level zerolog.Level) *observedLogs {
+	return o.Filter(func(e observedEntry) bool { return e.Level == level })
+}
+
+func (o *observedLogs) FilterFieldKey(key string) *observedLogs {
+	return o.Filter(func(e observedEntry) bool {
+		_, ok := e.Fields[key]
+		return ok
+	})
+}
+
+// FilterField keeps the entries whose field key holds value once both are
+// compared as JSON.
+func (o *observedLogs) FilterField(key string, value interface{}) *observedLogs {
+	want := asJSONValue(value)
+	return o.Filter(func(e observedEntry) bool {
+		got, ok := e.Fields[key]
+		return ok && reflect.DeepEqual(got, want)
+	})
+}
+
+func asJSONValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// AssertLogged fails t unless an entry with msg was captured.
+func (o *observedLogs) AssertLogged(t testing.TB, msg string) {
+	t.Helper()
+	if o.FilterMessage(msg).Len() == 0 {
+		t.Errorf("expected log entry %%q, got %%d other entries", msg, o.Len())
+	}
+}
+
+// AssertNotLogged fails t if an entry with msg was captured.
+func (o *observedLogs) AssertNotLogged(t testing.TB, msg string) {
+	t.Helper()
+	if n := o.FilterMessage(msg).Len(); n > 0 {
+		t.Errorf("unexpected log entry %%q logged %%d time(s)", msg, n)
+	}
+}
+`
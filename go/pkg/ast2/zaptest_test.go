@@ -0,0 +1,128 @@
+package ast2
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTestLoggers(t *testing.T) {
+	runMigrateCases(t, []migrateCase{{
+		name: "zaptest logger",
+		src: `package p
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestA(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	l.Info("hi", zap.String("a", "b"))
+}
+`,
+		opts:    Options{Filename: "testdata/p_test.go"},
+		want:    []string{"l := zerolog.New(zerolog.NewTestWriter(t))", `l.Info().Str("a", "b").Msg("hi")`},
+		notWant: []string{"zaptest"},
+	}, {
+		name: "observer",
+		src: `package p
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestA(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	l := zap.New(core)
+	l.Warn("w")
+	if logs.Len() != 1 {
+		t.Fatal("not logged")
+	}
+}
+`,
+		opts:    Options{Filename: "testdata/p_test.go"},
+		want:    []string{"core, logs := newLogObserver(zerolog.WarnLevel)", "l := zerolog.New(core)", `l.Warn().Msg("w")`},
+		notWant: []string{"go.uber.org/zap"},
+		diags:   []string{CategoryObserverHelper},
+	}, {
+		name: "same name in another function",
+		src: `package p
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestA(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	helper(l)
+}
+
+func helper(l *zap.Logger) { l.Info("helper") }
+`,
+		opts: Options{Filename: "testdata/p_test.go"},
+		want: []string{"l := zerolog.New(zerolog.NewTestWriter(t))", `l.Info("helper")`},
+	}, {
+		name: "dropped options",
+		src: `package p
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestA(t *testing.T) {
+	core, _ := observer.New(zap.InfoLevel)
+	l := zap.New(core, zap.AddCaller())
+	l.Info("hi")
+}
+`,
+		opts:    Options{Filename: "testdata/p_test.go"},
+		want:    []string{"l := zerolog.New(core)"},
+		notWant: []string{"AddCaller"},
+		diags:   []string{CategoryObserverHelper, CategoryTestLogger},
+	}})
+}
+
+func TestTestLoggersReview(t *testing.T) {
+	const src = `package p
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestA(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	l.Info("hi", zap.String("a", "b"))
+}
+`
+	cfg := defaultConfig()
+	rv, err := newReviewer(strings.NewReader("n\n"), io.Discard, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.review = rv
+	out, _, err := processSource("testdata/p_test.go", []byte(src), cfg, &report{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && string(out) != src {
+		t.Errorf("rejected logger rewritten:\n%s", out)
+	}
+	if rv.total != 1 {
+		t.Errorf("asked %d time(s), want once", rv.total)
+	}
+}